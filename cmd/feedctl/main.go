@@ -0,0 +1,180 @@
+// Command feedctl runs operational tasks directly against MongoDB and Redis.
+//
+//	go run ./cmd/feedctl rebuild -user <id>          rebuild one user's feed
+//	go run ./cmd/feedctl rebuild -all                rebuild every user's feed
+//	go run ./cmd/feedctl flush-cache -user <id>      drop a user's cached feed pages
+//	go run ./cmd/feedctl celebrity -user <id> -set true|false|auto
+//	go run ./cmd/feedctl graph [-repair]             check, and optionally repair, the follow graph;
+//	                                                 without -repair it fails when problems are found
+//	go run ./cmd/feedctl stats -user <id>            show a user's feed stats
+//	go run ./cmd/feedctl export -user <id>           print everything stored about a user
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"feed/controllers"
+	"feed/initializers"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// commands maps each subcommand to its implementation, which parses its own flags
+var commands = map[string]func(ctx context.Context, args []string) error{
+	"rebuild":     rebuild,
+	"flush-cache": flushCache,
+	"celebrity":   celebrity,
+	"graph":       graph,
+	"stats":       stats,
+	"export":      export,
+}
+
+func main() {
+	if len(os.Args) < 2 || commands[os.Args[1]] == nil {
+		fmt.Fprintln(os.Stderr, "Usage: feedctl rebuild|flush-cache|celebrity|graph|stats|export [flags]")
+		os.Exit(2)
+	}
+
+	initializers.LoadEnvVar()
+	controllers.Configure(controllers.LoadDeps())
+
+	if err := commands[os.Args[1]](context.Background(), os.Args[2:]); err != nil {
+		log.Fatal(os.Args[1], " failed: ", err)
+	}
+}
+
+func rebuild(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("rebuild", flag.ExitOnError)
+	user := flags.String("user", "", "ID of the user whose feed is rebuilt")
+	all := flags.Bool("all", false, "rebuild every user's feed")
+	flags.Parse(args)
+
+	if *all {
+		rebuilt, err := controllers.RebuildAllFeeds(ctx)
+		fmt.Println("Rebuilt", rebuilt, "feeds")
+		return err
+	}
+	id, err := userID(flags, *user)
+	if err != nil {
+		return err
+	}
+	posts, err := controllers.RebuildFeed(ctx, id)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Rebuilt feed of", id.Hex(), "with", posts, "posts")
+	return nil
+}
+
+func flushCache(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("flush-cache", flag.ExitOnError)
+	user := flags.String("user", "", "ID of the user whose cached feed is dropped")
+	flags.Parse(args)
+
+	id, err := userID(flags, *user)
+	if err != nil {
+		return err
+	}
+	if err = controllers.FlushFeedCache(ctx, id); err != nil {
+		return err
+	}
+	fmt.Println("Flushed feed cache of", id.Hex())
+	return nil
+}
+
+func celebrity(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("celebrity", flag.ExitOnError)
+	user := flags.String("user", "", "ID of the user")
+	set := flags.String("set", "", "true or false to pin the status, auto to hand it back to the follower count policy")
+	flags.Parse(args)
+
+	id, err := userID(flags, *user)
+	if err != nil {
+		return err
+	}
+	switch *set {
+	case "true", "false":
+		err = controllers.SetCelebrity(ctx, id, *set == "true", true)
+	case "auto":
+		// Keep the current status so the policy's thresholds decide from where the user stands
+		var current *controllers.FeedStats
+		if current, err = controllers.GetFeedStats(ctx, id); err != nil {
+			return err
+		}
+		err = controllers.SetCelebrity(ctx, id, current.IsCelebrity, false)
+	default:
+		return fmt.Errorf("-set must be true, false or auto")
+	}
+	if err != nil {
+		return err
+	}
+	return printJSON(controllers.GetFeedStats(ctx, id))
+}
+
+func graph(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("graph", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "fix the problems that are found")
+	flags.Parse(args)
+
+	// The report is printed even when the check fails part way, it holds what was found so far
+	report, err := controllers.CheckGraph(ctx, *repair)
+	printJSON(report, nil)
+	if err != nil {
+		return err
+	}
+	if issues := report.Issues(); issues > 0 && !*repair {
+		return fmt.Errorf("%d problems found, -repair fixes them", issues)
+	}
+	return nil
+}
+
+func stats(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	user := flags.String("user", "", "ID of the user")
+	flags.Parse(args)
+
+	id, err := userID(flags, *user)
+	if err != nil {
+		return err
+	}
+	return printJSON(controllers.GetFeedStats(ctx, id))
+}
+
+func export(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	user := flags.String("user", "", "ID of the user")
+	flags.Parse(args)
+
+	id, err := userID(flags, *user)
+	if err != nil {
+		return err
+	}
+	return printJSON(controllers.ExportUser(ctx, id))
+}
+
+// userID parses the -user flag, which every per-user command requires
+func userID(flags *flag.FlagSet, value string) (primitive.ObjectID, error) {
+	if value == "" {
+		flags.Usage()
+		return primitive.NilObjectID, fmt.Errorf("-user is required")
+	}
+	return primitive.ObjectIDFromHex(value)
+}
+
+// printJSON prints what a command returned unless it failed
+func printJSON(value interface{}, err error) error {
+	if err != nil {
+		return err
+	}
+	output, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(output))
+	return nil
+}
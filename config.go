@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"feed/initializers"
+)
+
+// config is the process level configuration every command shares, read once at startup
+type config struct {
+	// WorkerAddr is where the worker serves its health check
+	WorkerAddr string
+	// ShutdownTimeout is how long a stopping worker waits for its jobs
+	ShutdownTimeout time.Duration
+
+	PostPurgeInterval       time.Duration
+	AccountDeletionInterval time.Duration
+	CelebrityPolicyInterval time.Duration
+	ChangePollInterval      time.Duration
+	OutboxRelayInterval     time.Duration
+	WebhookDispatchInterval time.Duration
+	SuggestionInterval      time.Duration
+}
+
+func loadConfig() config {
+	workerAddr := os.Getenv("WORKER_ADDR")
+	if workerAddr == "" {
+		workerAddr = ":8081"
+	}
+	return config{
+		WorkerAddr:              workerAddr,
+		ShutdownTimeout:         initializers.EnvDuration("WORKER_SHUTDOWN_TIMEOUT", 30*time.Second),
+		PostPurgeInterval:       initializers.EnvDuration("POST_PURGE_INTERVAL", time.Hour),
+		AccountDeletionInterval: initializers.EnvDuration("ACCOUNT_DELETION_INTERVAL", 10*time.Second),
+		CelebrityPolicyInterval: initializers.EnvDuration("CELEBRITY_POLICY_INTERVAL", 10*time.Minute),
+		ChangePollInterval:      initializers.EnvDuration("CHANGE_POLL_INTERVAL", 5*time.Second),
+		OutboxRelayInterval:     initializers.EnvDuration("OUTBOX_RELAY_INTERVAL", time.Second),
+		WebhookDispatchInterval: initializers.EnvDuration("WEBHOOK_DISPATCH_INTERVAL", 5*time.Second),
+		SuggestionInterval:      initializers.EnvDuration("SUGGESTION_INTERVAL", 6*time.Hour),
+	}
+}
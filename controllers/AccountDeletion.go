@@ -0,0 +1,458 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"feed/initializers"
+	"feed/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var accountDeletionsCollection *mongo.Collection = initializers.OpenCollection(initializers.Client, "account_deletion")
+
+// Account deletion job statuses
+const (
+	DeletionPending   = "pending"
+	DeletionRunning   = "running"
+	DeletionCompleted = "completed"
+	DeletionFailed    = "failed"
+)
+
+// accountDeletionLease is how long a worker owns a job before another may pick it up
+const accountDeletionLease = 5 * time.Minute
+
+// accountDeletionStep removes one kind of trace of the user. Steps must be safe to run twice.
+type accountDeletionStep struct {
+	name string
+	run  func(ctx context.Context, job *models.AccountDeletionJob) error
+}
+
+// accountDeletionSteps run in order, the user document goes last so the job can always be found again
+var accountDeletionSteps = []accountDeletionStep{
+	{"follow_graph", deleteFollowGraph},
+	{"follow_requests", deleteUserFollowRequests},
+	{"blocks", deleteUserBlocks},
+	{"mutes", deleteUserMutes},
+	{"tag_follows", deleteUserTagFollows},
+	{"username_redirects", deleteUserUsernameRedirects},
+	{"notifications", deleteUserNotifications},
+	{"likes", deleteUserLikes},
+	{"posts", deleteUserPosts},
+	{"feed", deleteUserFeed},
+	{"cache", deleteUserCache},
+	{"user", deleteUserDocument},
+}
+
+// DeleteUser hides the user straight away and queues the removal of everything they left behind.
+// Only the user can delete their account, asking again restarts a failed deletion.
+func DeleteUser(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if !checkSelf(c, id) {
+		return
+	}
+
+	now := time.Now()
+	result, err := usersCollection.UpdateOne(
+		c.Request.Context(),
+		bson.M{"_id": id},
+		bson.M{"$min": bson.M{"deleted_at": now}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	_, err = accountDeletionsCollection.UpdateOne(
+		c.Request.Context(),
+		bson.M{"user_id": id, "status": DeletionFailed},
+		bson.M{
+			"$set":   bson.M{"status": DeletionPending, "attempts": 0, "lease_until": time.Time{}, "updated_at": now},
+			"$unset": bson.M{"error": ""},
+		},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to schedule account deletion"})
+		return
+	}
+
+	// Reuse the unfinished job if the deletion was already requested
+	var job models.AccountDeletionJob
+	err = accountDeletionsCollection.FindOneAndUpdate(
+		c.Request.Context(),
+		bson.M{"user_id": id, "status": bson.M{"$ne": DeletionCompleted}},
+		bson.M{"$setOnInsert": bson.M{
+			"status":          DeletionPending,
+			"completed_steps": []string{},
+			"posts_removed":   0,
+			"attempts":        0,
+			"lease_until":     time.Time{},
+			"created_at":      now,
+			"updated_at":      now,
+		}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&job)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to schedule account deletion"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// GetAccountDeletion reports the progress of an account deletion job to the user being deleted
+func GetAccountDeletion(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	var job models.AccountDeletionJob
+	err = accountDeletionsCollection.FindOne(c.Request.Context(), bson.M{"_id": id}).Decode(&job)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account deletion not found"})
+		return
+	}
+	if !checkSelf(c, job.UserID) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"job":         job,
+		"total_steps": len(accountDeletionSteps),
+	})
+}
+
+// StartAccountDeletionWorker processes queued account deletions until ctx is cancelled.
+// Jobs left running by a crashed worker are picked up again once their lease expires,
+// until they have been tried AccountDeletionMaxAttempts times.
+func StartAccountDeletionWorker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		heartbeat("account_deletion", interval)
+		if err := failExhaustedAccountDeletions(ctx); err != nil {
+			fmt.Println("Error failing account deletions:", err)
+		}
+		for {
+			job, err := claimAccountDeletion(ctx)
+			if err != nil {
+				if err != mongo.ErrNoDocuments {
+					fmt.Println("Error claiming account deletion:", err)
+				}
+				break
+			}
+			if err = runAccountDeletion(ctx, job); err != nil {
+				fmt.Println("Error deleting account", job.UserID.Hex()+":", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// claimAccountDeletion takes the lease on the oldest unfinished job nobody else is working on
+func claimAccountDeletion(ctx context.Context) (*models.AccountDeletionJob, error) {
+	now := time.Now()
+	var job models.AccountDeletionJob
+	err := accountDeletionsCollection.FindOneAndUpdate(
+		ctx,
+		bson.M{
+			"status":      bson.M{"$in": []string{DeletionPending, DeletionRunning}},
+			"lease_until": bson.M{"$lt": now},
+			"attempts":    bson.M{"$lt": deps.AccountDeletionMaxAttempts},
+		},
+		bson.M{
+			"$set": bson.M{
+				"status":      DeletionRunning,
+				"lease_until": now.Add(accountDeletionLease),
+				"updated_at":  now,
+			},
+			"$inc": bson.M{"attempts": 1},
+		},
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "created_at", Value: 1}}).
+			SetReturnDocument(options.After),
+	).Decode(&job)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// failExhaustedAccountDeletions marks the jobs that used up their attempts without finishing as failed.
+// Their users stay hidden, deleting the account again restarts the job.
+func failExhaustedAccountDeletions(ctx context.Context) error {
+	now := time.Now()
+	_, err := accountDeletionsCollection.UpdateMany(
+		ctx,
+		bson.M{
+			"status":      bson.M{"$in": []string{DeletionPending, DeletionRunning}},
+			"lease_until": bson.M{"$lt": now},
+			"attempts":    bson.M{"$gte": deps.AccountDeletionMaxAttempts},
+		},
+		bson.M{"$set": bson.M{"status": DeletionFailed, "updated_at": now}},
+	)
+	return err
+}
+
+// runAccountDeletion runs the steps the job has not completed yet, recording each as it finishes
+func runAccountDeletion(ctx context.Context, job *models.AccountDeletionJob) error {
+	completed := make(map[string]bool, len(job.CompletedSteps))
+	for _, step := range job.CompletedSteps {
+		completed[step] = true
+	}
+
+	for _, step := range accountDeletionSteps {
+		if completed[step.name] {
+			continue
+		}
+
+		if err := step.run(ctx, job); err != nil {
+			// Leave the job running, it is retried when the lease runs out, unless it is out of attempts
+			set := bson.M{"error": step.name + ": " + err.Error(), "updated_at": time.Now()}
+			if job.Attempts >= deps.AccountDeletionMaxAttempts {
+				set["status"] = DeletionFailed
+			}
+			_, _ = accountDeletionsCollection.UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{"$set": set})
+			return err
+		}
+
+		now := time.Now()
+		_, err := accountDeletionsCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": job.ID},
+			bson.M{
+				"$addToSet": bson.M{"completed_steps": step.name},
+				"$set": bson.M{
+					"lease_until": now.Add(accountDeletionLease),
+					"updated_at":  now,
+				},
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := accountDeletionsCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": job.ID},
+		bson.M{
+			"$set":   bson.M{"status": DeletionCompleted, "updated_at": time.Now()},
+			"$unset": bson.M{"error": ""},
+		},
+	)
+	return err
+}
+
+// deleteFollowGraph removes every edge touching the user, fixing the counts on the other end of each.
+// Each edge goes in its own transaction so a resumed job never counts one twice.
+func deleteFollowGraph(ctx context.Context, job *models.AccountDeletionJob) error {
+	for {
+		var edge models.Follow
+		err := followsCollection.FindOne(
+			ctx,
+			bson.M{"$or": []bson.M{{"follower_id": job.UserID}, {"followee_id": job.UserID}}},
+		).Decode(&edge)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		err = withTransaction(ctx, func(sc mongo.SessionContext) error {
+			err := removeFollowEdge(sc, edge.FollowerID, edge.FolloweeID)
+			if err == errNotFollowing {
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// deleteUserFollowRequests removes requests the user sent or received
+func deleteUserFollowRequests(ctx context.Context, job *models.AccountDeletionJob) error {
+	_, err := followRequestsCollection.DeleteMany(ctx, bson.M{"$or": []bson.M{
+		{"requester_id": job.UserID},
+		{"target_id": job.UserID},
+	}})
+	return err
+}
+
+// deleteUserBlocks removes blocks the user placed or received
+func deleteUserBlocks(ctx context.Context, job *models.AccountDeletionJob) error {
+	_, err := blocksCollection.DeleteMany(ctx, bson.M{"$or": []bson.M{
+		{"blocker_id": job.UserID},
+		{"blocked_id": job.UserID},
+	}})
+	return err
+}
+
+// deleteUserMutes removes the user's mutes and other users' mutes of them
+func deleteUserMutes(ctx context.Context, job *models.AccountDeletionJob) error {
+	_, err := mutesCollection.DeleteMany(ctx, bson.M{"$or": []bson.M{
+		{"user_id": job.UserID},
+		{"kind": models.MuteUser, "value": job.UserID.Hex()},
+	}})
+	return err
+}
+
+// deleteUserTagFollows removes the tags the user follows
+func deleteUserTagFollows(ctx context.Context, job *models.AccountDeletionJob) error {
+	_, err := tagFollowsCollection.DeleteMany(ctx, bson.M{"user_id": job.UserID})
+	return err
+}
+
+// deleteUserUsernameRedirects frees the user's old handles, the current one goes with the user document
+func deleteUserUsernameRedirects(ctx context.Context, job *models.AccountDeletionJob) error {
+	_, err := usernameRedirectsCollection.DeleteMany(ctx, bson.M{"user_id": job.UserID})
+	return err
+}
+
+// deleteUserNotifications removes the user's notifications and those the user caused
+func deleteUserNotifications(ctx context.Context, job *models.AccountDeletionJob) error {
+	_, err := notificationsCollection.DeleteMany(ctx, bson.M{"$or": []bson.M{
+		{"user_id": job.UserID},
+		{"actor_id": job.UserID, "actor_count": bson.M{"$lte": 1}},
+	}})
+	if err != nil {
+		return err
+	}
+
+	// Groups the user was part of lose them but stay for the other actors
+	without := func(field string) bson.M {
+		return bson.M{"$filter": bson.M{
+			"input": bson.M{"$ifNull": bson.A{field, bson.A{}}},
+			"cond":  bson.M{"$ne": bson.A{"$$this", job.UserID}},
+		}}
+	}
+	_, err = notificationsCollection.UpdateMany(ctx, bson.M{"$or": []bson.M{{"actor_ids": job.UserID}, {"group_actor_ids": job.UserID}}}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"actor_ids":       without("$actor_ids"),
+			"group_actor_ids": without("$group_actor_ids"),
+			"actor_count":     bson.M{"$max": bson.A{1, bson.M{"$subtract": bson.A{"$actor_count", 1}}}},
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"actor_id": bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$actor_ids", 0}}, "$actor_id"}},
+		}}},
+	})
+	return err
+}
+
+// deleteUserLikes takes back the user's likes one at a time, each with its post's count
+func deleteUserLikes(ctx context.Context, job *models.AccountDeletionJob) error {
+	for {
+		var like models.Like
+		err := likesCollection.FindOne(ctx, bson.M{"user_id": job.UserID}).Decode(&like)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		err = withTransaction(ctx, func(sc mongo.SessionContext) error {
+			return removeLike(sc, like.PostID, like.UserID)
+		})
+		if err != nil && !errors.Is(err, errNotLiked) {
+			return err
+		}
+	}
+}
+
+// deleteUserPosts purges the user's posts in batches, along with other users' plain reposts of them
+func deleteUserPosts(ctx context.Context, job *models.AccountDeletionJob) error {
+	for {
+		cursor, err := postsCollection.Find(
+			ctx,
+			bson.M{"user_id": job.UserID},
+			options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(postPurgeBatchSize),
+		)
+		if err != nil {
+			return err
+		}
+		var posts []models.Post
+		if err = cursor.All(ctx, &posts); err != nil {
+			return err
+		}
+		if len(posts) == 0 {
+			return nil
+		}
+
+		postIDs := make([]primitive.ObjectID, len(posts))
+		for i, post := range posts {
+			postIDs[i] = post.ID
+		}
+
+		var reposts []models.Post
+		cursor, err = postsCollection.Find(
+			ctx,
+			bson.M{"repost_of": bson.M{"$in": postIDs}, "content": ""},
+			options.Find().SetProjection(bson.M{"_id": 1}),
+		)
+		if err != nil {
+			return err
+		}
+		if err = cursor.All(ctx, &reposts); err != nil {
+			return err
+		}
+		for _, repost := range reposts {
+			postIDs = append(postIDs, repost.ID)
+		}
+
+		if err = purgePosts(ctx, postIDs); err != nil {
+			return err
+		}
+		_, err = accountDeletionsCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": job.ID},
+			bson.M{"$inc": bson.M{"posts_removed": len(posts)}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// deleteUserFeed removes the user's precomputed feed
+func deleteUserFeed(ctx context.Context, job *models.AccountDeletionJob) error {
+	_, err := feedsCollection.DeleteMany(ctx, bson.M{"user_id": job.UserID})
+	return err
+}
+
+// deleteUserCache drops the user's cached feed pages, suggestions and unread count
+func deleteUserCache(ctx context.Context, job *models.AccountDeletionJob) error {
+	if err := invalidateFeedCache(ctx, job.UserID); err != nil {
+		return err
+	}
+	return deps.Redis.Del(ctx, suggestionsKey(job.UserID), unreadCountKey(job.UserID)).Err()
+}
+
+// deleteUserDocument removes the user itself
+func deleteUserDocument(ctx context.Context, job *models.AccountDeletionJob) error {
+	_, err := usersCollection.DeleteOne(ctx, bson.M{"_id": job.UserID})
+	return err
+}
@@ -0,0 +1,21 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireAdmin only lets through requests whose X-Admin-Token header matches ADMIN_TOKEN.
+// Admin routes are closed when ADMIN_TOKEN is not set.
+func RequireAdmin(c *gin.Context) {
+	token := os.Getenv("ADMIN_TOKEN")
+	given := c.GetHeader("X-Admin-Token")
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(given)) != 1 {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		return
+	}
+	c.Next()
+}
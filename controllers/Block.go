@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"feed/initializers"
+	"feed/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var blocksCollection *mongo.Collection = initializers.OpenCollection(initializers.Client, "blocks")
+
+var (
+	errAlreadyBlocked = errors.New("user already blocked")
+	errBlocked        = errors.New("user is blocked")
+)
+
+// BlockUser blocks a user and removes any follow or follow request between the two, in both directions
+func BlockUser(c *gin.Context) {
+	blockerID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	blockedID, err := primitive.ObjectIDFromHex(c.Param("blockedID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid blocked user ID"})
+		return
+	}
+	if blockerID == blockedID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Users cannot block themselves"})
+		return
+	}
+
+	err = withTransaction(c.Request.Context(), func(sc mongo.SessionContext) error {
+		if err := checkFollowPair(sc, blockerID, blockedID); err != nil {
+			return err
+		}
+
+		_, err := blocksCollection.InsertOne(sc, models.Block{
+			BlockerID: blockerID,
+			BlockedID: blockedID,
+			CreatedAt: time.Now(),
+		})
+		if mongo.IsDuplicateKeyError(err) {
+			return errAlreadyBlocked
+		} else if err != nil {
+			return err
+		}
+
+		if err := removeFollowEdge(sc, blockerID, blockedID); err != nil && err != errNotFollowing {
+			return err
+		}
+		if err := removeFollowEdge(sc, blockedID, blockerID); err != nil && err != errNotFollowing {
+			return err
+		}
+		return deleteFollowRequestPair(sc, blockerID, blockedID)
+	})
+	if errors.Is(err, errAlreadyBlocked) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		respondFollowError(c, err, "Failed to block user")
+		return
+	}
+
+	invalidateBlockPair(c.Request.Context(), blockerID, blockedID)
+
+	// Either user may have lost a follower and dropped below the demotion threshold
+	filter := bson.M{"_id": bson.M{"$in": []primitive.ObjectID{blockerID, blockedID}}}
+	if err = applyCelebrityPolicy(c.Request.Context(), filter); err != nil {
+		fmt.Println("Error applying celebrity policy:", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Successfully blocked user"})
+}
+
+// UnblockUser lifts a block. Follows removed by the block are not restored.
+func UnblockUser(c *gin.Context) {
+	blockerID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	blockedID, err := primitive.ObjectIDFromHex(c.Param("blockedID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid blocked user ID"})
+		return
+	}
+
+	result, err := blocksCollection.DeleteOne(c.Request.Context(), bson.M{"blocker_id": blockerID, "blocked_id": blockedID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unblock user"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User is not blocked"})
+		return
+	}
+
+	invalidateBlockPair(c.Request.Context(), blockerID, blockedID)
+	c.JSON(http.StatusOK, gin.H{"message": "Successfully unblocked user"})
+}
+
+// ListBlocks pages through the users a user has blocked, most recent first
+func ListBlocks(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	limit, err := parseLimit(c, 20, 100)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit number"})
+		return
+	}
+	filter, err := cursorFilter(c.Query("cursor"), "created_at")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+	filter["blocker_id"] = userID
+
+	var blocks []models.Block
+	cursor, err := blocksCollection.Find(
+		c.Request.Context(),
+		filter,
+		options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+			SetLimit(int64(limit+1)),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve blocks"})
+		return
+	}
+	if err = cursor.All(c.Request.Context(), &blocks); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode blocks"})
+		return
+	}
+
+	nextCursor := ""
+	if len(blocks) > limit {
+		blocks = blocks[:limit]
+		last := blocks[len(blocks)-1]
+		nextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+
+	ids := make([]primitive.ObjectID, len(blocks))
+	for i, block := range blocks {
+		ids[i] = block.BlockedID
+	}
+	summaries, err := userSummaries(c.Request.Context(), ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":       summaries,
+		"next_cursor": nextCursor,
+	})
+}
+
+// invalidateBlockPair drops both users' cached feeds so the block shows up straight away
+func invalidateBlockPair(ctx context.Context, a, b primitive.ObjectID) {
+	for _, userID := range []primitive.ObjectID{a, b} {
+		if err := invalidateFeedCache(ctx, userID); err != nil {
+			fmt.Println("Error invalidating feed cache:", err)
+		}
+	}
+}
+
+// isBlocked reports whether either user has blocked the other
+func isBlocked(ctx context.Context, a, b primitive.ObjectID) (bool, error) {
+	count, err := blocksCollection.CountDocuments(ctx, bson.M{"$or": []bson.M{
+		{"blocker_id": a, "blocked_id": b},
+		{"blocker_id": b, "blocked_id": a},
+	}})
+	return count > 0, err
+}
@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"feed/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Feed migrations left pending on a user whose fan-out mode changed
+const (
+	feedMigrationToPull = "to_pull"
+	feedMigrationToPush = "to_push"
+)
+
+// feedMigrationPostLimit is how many of the user's latest posts are moved in or out of follower feeds
+const feedMigrationPostLimit = 200
+
+// feedMigrationBatchSize is how many follower feeds are rewritten per update
+const feedMigrationBatchSize = 500
+
+// SetCelebrityStatus is the admin override for a user's fan-out mode. The value is pinned so
+// the automatic policy leaves it alone, unless the request passes "pinned": false.
+func SetCelebrityStatus(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var status struct {
+		IsCelebrity bool  `json:"is_celebrity"`
+		Pinned      *bool `json:"pinned"`
+	}
+	if err := c.ShouldBindJSON(&status); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	pinned := status.Pinned == nil || *status.Pinned
+
+	err = SetCelebrity(c.Request.Context(), id, status.IsCelebrity, pinned)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update celebrity status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Celebrity status updated"})
+}
+
+// applyCelebrityPolicy promotes and demotes the unpinned users matching filter according to
+// their follower count, queueing a feed migration for each user whose mode changes
+func applyCelebrityPolicy(ctx context.Context, filter bson.M) error {
+	promote := bson.M{
+		"celebrity_pinned": bson.M{"$ne": true},
+		"is_celebrity":     bson.M{"$ne": true},
+		"follower_count":   bson.M{"$gte": deps.CelebrityPromoteThreshold},
+	}
+	demote := bson.M{
+		"celebrity_pinned": bson.M{"$ne": true},
+		"is_celebrity":     true,
+		"follower_count":   bson.M{"$lt": deps.CelebrityDemoteThreshold},
+	}
+
+	_, err := usersCollection.UpdateMany(
+		ctx,
+		notDeleted(bson.M{"$and": []bson.M{filter, promote}}),
+		bson.M{"$set": bson.M{"is_celebrity": true, "feed_migration": feedMigrationToPull}},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = usersCollection.UpdateMany(
+		ctx,
+		notDeleted(bson.M{"$and": []bson.M{filter, demote}}),
+		bson.M{"$set": bson.M{"is_celebrity": false, "feed_migration": feedMigrationToPush}},
+	)
+	return err
+}
+
+// queueFeedMigration records that the user's followers' feeds must be moved to the new mode
+func queueFeedMigration(ctx context.Context, userID primitive.ObjectID, isCelebrity bool) error {
+	migration := feedMigrationToPush
+	if isCelebrity {
+		migration = feedMigrationToPull
+	}
+	_, err := usersCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"feed_migration": migration}},
+	)
+	return err
+}
+
+// StartCelebrityPolicy periodically re-evaluates every user's mode and runs pending feed migrations
+func StartCelebrityPolicy(ctx context.Context, interval time.Duration) {
+	if deps.CelebrityDemoteThreshold > deps.CelebrityPromoteThreshold {
+		fmt.Println("CELEBRITY_DEMOTE_THRESHOLD is above CELEBRITY_PROMOTE_THRESHOLD, users will flap between modes")
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		heartbeat("celebrity_policy", interval)
+		if err := applyCelebrityPolicy(ctx, bson.M{}); err != nil {
+			fmt.Println("Error applying celebrity policy:", err)
+		}
+		if err := runFeedMigrations(ctx); err != nil {
+			fmt.Println("Error migrating feeds:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runFeedMigrations works through every user with a pending feed migration
+func runFeedMigrations(ctx context.Context) error {
+	for {
+		var user models.User
+		err := usersCollection.FindOne(ctx, bson.M{"feed_migration": bson.M{"$exists": true}}).Decode(&user)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err = migrateFollowerFeeds(ctx, &user); err != nil {
+			return err
+		}
+
+		// Only clear the migration we ran, the mode may have flipped again meanwhile
+		_, err = usersCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": user.ID, "feed_migration": user.FeedMigration},
+			bson.M{"$unset": bson.M{"feed_migration": ""}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// migrateFollowerFeeds moves the user's latest posts out of follower feeds when they switch
+// to pull mode, since GetFeed now reads them directly, and back in when they switch to push
+func migrateFollowerFeeds(ctx context.Context, user *models.User) error {
+	postFilter := bson.M{"user_id": user.ID}
+	if user.FeedMigration == feedMigrationToPush {
+		postFilter = notDeleted(postFilter)
+	}
+	var posts []models.Post
+	cursor, err := postsCollection.Find(
+		ctx,
+		postFilter,
+		options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: -1}}).
+			SetLimit(feedMigrationPostLimit).
+			SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	if err = cursor.All(ctx, &posts); err != nil {
+		return err
+	}
+	postIDs := make([]primitive.ObjectID, len(posts))
+	for i, post := range posts {
+		postIDs[i] = post.ID
+	}
+
+	update := bson.M{"$pull": bson.M{"posts": bson.M{"$in": postIDs}}}
+	if user.FeedMigration == feedMigrationToPush {
+		update = bson.M{"$addToSet": bson.M{"posts": bson.M{"$each": postIDs}}}
+	}
+
+	cursor, err = followsCollection.Find(
+		ctx,
+		bson.M{"followee_id": user.ID},
+		options.Find().SetProjection(bson.M{"follower_id": 1}).SetBatchSize(feedMigrationBatchSize),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	batch := make([]primitive.ObjectID, 0, feedMigrationBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := feedsCollection.UpdateMany(ctx, bson.M{"user_id": bson.M{"$in": batch}}, update); err != nil {
+			return err
+		}
+		for _, followerID := range batch {
+			if err := invalidateFeedCache(ctx, followerID); err != nil {
+				return err
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for cursor.Next(ctx) {
+		var edge models.Follow
+		if err := cursor.Decode(&edge); err != nil {
+			return err
+		}
+		batch = append(batch, edge.FollowerID)
+		if len(batch) == feedMigrationBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
@@ -0,0 +1,575 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"feed/initializers"
+	"feed/models"
+	"feed/visibility"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// changeStreamStateCollection keeps each watcher's resume token, or its position when polling,
+// and the lease on it
+var changeStreamStateCollection *mongo.Collection = initializers.OpenCollection(initializers.Client, "change_stream_state")
+
+// Server error codes that decide how a watcher starts
+const (
+	// Change streams need a replica set or sharded cluster
+	errCodeChangeStreamUnsupported = 40573
+	// The saved resume token fell off the oplog
+	errCodeChangeStreamHistoryLost = 286
+)
+
+// pollOverlap is how far back each poll reaches into the previous one
+const pollOverlap = 5 * time.Second
+
+// changeStreamLease is how long a replica owns a watcher without renewing. Only the owner
+// handles the watcher's changes and saves its position, the other replicas wait for the lease.
+const changeStreamLease = 30 * time.Second
+
+// changeStreamOwner names this process on the leases it holds
+var changeStreamOwner = func() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "feed"
+	}
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}()
+
+// postFeedFields are the post fields whose changes reach feeds or the search index. Counters
+// like like_count are left out, they change far too often to drop caches over.
+var postFeedFields = []string{
+	"content", "tags", "entities", "mentions", "visibility", "private_to",
+	"repost_of_user_id", "edited_at", "deleted_at",
+}
+
+// changeStreamState is the stored position of one watcher
+type changeStreamState struct {
+	ID          string    `bson:"_id"`
+	ResumeToken bson.Raw  `bson:"resume_token,omitempty"`
+	PolledUntil time.Time `bson:"polled_until,omitempty"`
+	UpdatedAt   time.Time `bson:"updated_at"`
+}
+
+// changeEvent is the part of a change stream event the handlers need
+type changeEvent struct {
+	ResumeToken   bson.Raw `bson:"_id"`
+	OperationType string   `bson:"operationType"`
+	DocumentKey   struct {
+		ID primitive.ObjectID `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument bson.Raw `bson:"fullDocument"`
+}
+
+// changeHandler reacts to one change. doc is the document after the change, nil for deletes.
+type changeHandler func(ctx context.Context, operation string, id primitive.ObjectID, doc bson.Raw) error
+
+// changeWatcher ties a collection to its handler and the query polling falls back to
+type changeWatcher struct {
+	name       string
+	collection *mongo.Collection
+	handle     changeHandler
+	// pipeline filters the change stream, nil passes every change
+	pipeline mongo.Pipeline
+	// changedSince matches the documents a poll should pick up, given the end of the previous poll
+	changedSince func(since time.Time) bson.M
+}
+
+var changeWatchers = []changeWatcher{
+	{
+		name:       "posts",
+		collection: postsCollection,
+		handle:     handlePostChange,
+		pipeline:   updatesTouching(postFeedFields),
+		changedSince: func(since time.Time) bson.M {
+			return bson.M{"$or": []bson.M{
+				{"created_at": bson.M{"$gt": since}},
+				{"edited_at": bson.M{"$gt": since}},
+				{"deleted_at": bson.M{"$gt": since}},
+			}}
+		},
+	},
+	{
+		name:       "users",
+		collection: usersCollection,
+		handle:     handleUserChange,
+		changedSince: func(since time.Time) bson.M {
+			return bson.M{"$or": []bson.M{
+				{"created_at": bson.M{"$gt": since}},
+				{"deleted_at": bson.M{"$gt": since}},
+			}}
+		},
+	},
+}
+
+// updatesTouching passes inserts, replaces and deletes, and the updates that set or remove one
+// of the fields. Array updates name elements as "field.index", so the field is matched as a prefix.
+func updatesTouching(fields []string) mongo.Pipeline {
+	pattern := "^("
+	for i, field := range fields {
+		if i > 0 {
+			pattern += "|"
+		}
+		pattern += field
+	}
+	pattern += `)(\.|$)`
+
+	touched := func(names interface{}) bson.M {
+		return bson.M{"$gt": bson.A{
+			bson.M{"$size": bson.M{"$filter": bson.M{
+				"input": names,
+				"cond":  bson.M{"$regexMatch": bson.M{"input": "$$this", "regex": pattern}},
+			}}},
+			0,
+		}}
+	}
+	return mongo.Pipeline{{{Key: "$match", Value: bson.M{"$or": []bson.M{
+		{"operationType": bson.M{"$ne": "update"}},
+		{"$expr": touched(bson.M{"$map": bson.M{
+			"input": bson.M{"$objectToArray": "$updateDescription.updatedFields"},
+			"in":    "$$this.k",
+		}})},
+		{"$expr": touched("$updateDescription.removedFields")},
+	}}}}}
+}
+
+// StartChangeStreamWorker tails the posts and users collections until ctx is cancelled, turning
+// changes into feed fan-out, cache invalidation and search index updates. Where change streams
+// aren't available, as on a standalone mongod, it polls every pollInterval instead. Each
+// collection is watched by the one replica holding its lease.
+func StartChangeStreamWorker(ctx context.Context, pollInterval time.Duration) {
+	done := make(chan struct{})
+	for _, watcher := range changeWatchers {
+		go func(w changeWatcher) {
+			defer func() { done <- struct{}{} }()
+			w.lead(ctx, pollInterval)
+		}(watcher)
+	}
+	for range changeWatchers {
+		<-done
+	}
+}
+
+// lead runs the watcher whenever this replica holds its lease, trying to take it over otherwise
+func (w changeWatcher) lead(ctx context.Context, pollInterval time.Duration) {
+	for ctx.Err() == nil {
+		heartbeat("change_stream:"+w.name, changeStreamLease/3)
+		held, err := w.claimLease(ctx)
+		if err != nil && ctx.Err() == nil {
+			fmt.Printf("Error claiming the %s watcher lease: %v\n", w.name, err)
+		}
+		if held {
+			leaseCtx, cancel := context.WithCancel(ctx)
+			go w.renewLease(leaseCtx, cancel)
+			w.run(leaseCtx, pollInterval)
+			cancel()
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(changeStreamLease / 3):
+		}
+	}
+	// Hand the lease over right away rather than after it runs out
+	w.releaseLease()
+}
+
+// claimLease takes or renews the watcher's lease, reporting whether this replica holds it
+func (w changeWatcher) claimLease(ctx context.Context) (bool, error) {
+	now := time.Now()
+	_, err := changeStreamStateCollection.UpdateOne(
+		ctx,
+		bson.M{
+			"_id": w.name + ":lease",
+			"$or": []bson.M{{"owner": changeStreamOwner}, {"lease_until": bson.M{"$lt": now}}},
+		},
+		bson.M{"$set": bson.M{"owner": changeStreamOwner, "lease_until": now.Add(changeStreamLease), "updated_at": now}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		// Another replica holds it, the upsert ran into its lease
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// renewLease keeps the lease until ctx is cancelled, calling lost when it can't be renewed
+func (w changeWatcher) renewLease(ctx context.Context, lost context.CancelFunc) {
+	ticker := time.NewTicker(changeStreamLease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		held, err := w.claimLease(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		// While the watcher runs, its loop in lead waits, so the renewals are its heartbeat
+		heartbeat("change_stream:"+w.name, changeStreamLease/3)
+		if !held {
+			if err == nil {
+				err = errors.New("another replica took it over")
+			}
+			fmt.Printf("Lost the %s watcher lease: %v\n", w.name, err)
+			lost()
+			return
+		}
+	}
+}
+
+// releaseLease gives up the lease if this replica still holds it
+func (w changeWatcher) releaseLease() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := changeStreamStateCollection.DeleteOne(ctx, bson.M{"_id": w.name + ":lease", "owner": changeStreamOwner})
+	if err != nil {
+		fmt.Printf("Error releasing the %s watcher lease: %v\n", w.name, err)
+	}
+}
+
+// run watches the collection, restarting the stream after errors and switching to polling
+// for good if the server can't stream changes
+func (w changeWatcher) run(ctx context.Context, pollInterval time.Duration) {
+	for ctx.Err() == nil {
+		err := w.watch(ctx)
+		var serverErr mongo.ServerError
+		if errors.As(err, &serverErr) && serverErr.HasErrorCode(errCodeChangeStreamUnsupported) {
+			fmt.Printf("Change streams unavailable, polling %s every %s\n", w.name, pollInterval)
+			w.poll(ctx, pollInterval)
+			return
+		}
+		if err != nil && ctx.Err() == nil {
+			fmt.Printf("Error watching %s: %v\n", w.name, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}
+}
+
+// watch streams changes from the saved resume token, saving the token after each handled change
+// so a restart neither misses nor replays any
+func (w changeWatcher) watch(ctx context.Context) error {
+	state, err := loadChangeStreamState(ctx, w.name)
+	if err != nil {
+		return err
+	}
+
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if state.ResumeToken != nil {
+		opts.SetResumeAfter(state.ResumeToken)
+	}
+	stream, err := w.collection.Watch(ctx, w.pipeline, opts)
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(errCodeChangeStreamHistoryLost) {
+		// Changes since the token are gone, carry on from now rather than not at all
+		fmt.Printf("Resume token for %s expired, changes made while stopped were missed\n", w.name)
+		stream, err = w.collection.Watch(ctx, w.pipeline, options.ChangeStream().SetFullDocument(options.UpdateLookup))
+	}
+	if err != nil {
+		return err
+	}
+	defer stream.Close(ctx)
+
+	for stream.Next(ctx) {
+		var event changeEvent
+		if err := stream.Decode(&event); err != nil {
+			return err
+		}
+		switch event.OperationType {
+		case "insert", "update", "replace", "delete":
+			if err := w.handle(ctx, event.OperationType, event.DocumentKey.ID, event.FullDocument); err != nil {
+				// Not saving the token means the change is retried when the stream restarts
+				return fmt.Errorf("handling %s of %s: %w", event.OperationType, event.DocumentKey.ID.Hex(), err)
+			}
+		}
+		if err := saveChangeStreamState(ctx, w.name, bson.M{"resume_token": stream.ResumeToken()}); err != nil {
+			return err
+		}
+	}
+	return stream.Err()
+}
+
+// poll looks for changes by timestamp. Hard deletes, restores and audience changes leave no
+// timestamp to find, so only inserts, edits and soft deletes are picked up this way.
+func (w changeWatcher) poll(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.pollOnce(ctx); err != nil {
+			fmt.Printf("Error polling %s: %v\n", w.name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollOnce handles the changes made since the last poll and moves the saved position forward.
+// Polls overlap by pollOverlap so writes stamped just before a poll but committed after it are
+// still seen, the handlers don't mind seeing a change twice.
+func (w changeWatcher) pollOnce(ctx context.Context) error {
+	state, err := loadChangeStreamState(ctx, w.name+":poll")
+	if err != nil {
+		return err
+	}
+	started := time.Now()
+	since := state.PolledUntil
+	if since.IsZero() {
+		// First poll, nothing before now is new
+		return saveChangeStreamState(ctx, w.name+":poll", bson.M{"polled_until": started})
+	}
+
+	cursor, err := w.collection.Find(ctx, w.changedSince(since))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		doc := cursor.Current
+		operation := "update"
+		if created, ok := doc.Lookup("created_at").TimeOK(); ok && created.After(since) {
+			operation = "insert"
+		}
+		id, _ := doc.Lookup("_id").ObjectIDOK()
+		if err := w.handle(ctx, operation, id, doc); err != nil {
+			return fmt.Errorf("handling %s of %s: %w", operation, id.Hex(), err)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	return saveChangeStreamState(ctx, w.name+":poll", bson.M{"polled_until": started.Add(-pollOverlap)})
+}
+
+func loadChangeStreamState(ctx context.Context, name string) (*changeStreamState, error) {
+	var state changeStreamState
+	err := changeStreamStateCollection.FindOne(ctx, bson.M{"_id": name}).Decode(&state)
+	if err == mongo.ErrNoDocuments {
+		return &changeStreamState{ID: name}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func saveChangeStreamState(ctx context.Context, name string, fields bson.M) error {
+	fields["updated_at"] = time.Now()
+	_, err := changeStreamStateCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": name},
+		bson.M{"$set": fields},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// handlePostChange fans new posts out to followers' feeds and drops cached feed pages holding
+// posts that changed or went away, keeping a search index with its own copy in step
+func handlePostChange(ctx context.Context, operation string, id primitive.ObjectID, doc bson.Raw) error {
+	indexer, hasIndexer := searchIndex.(SearchIndexer)
+
+	var post models.Post
+	if doc != nil {
+		if err := bson.Unmarshal(doc, &post); err != nil {
+			return err
+		}
+	}
+	if doc == nil || post.DeletedAt != nil {
+		if hasIndexer {
+			if err := indexer.RemovePost(ctx, id); err != nil {
+				return err
+			}
+		}
+		return invalidateFeedsContaining(ctx, []primitive.ObjectID{id})
+	}
+
+	if hasIndexer {
+		if err := indexer.IndexPost(ctx, &post); err != nil {
+			return err
+		}
+	}
+	if operation == "insert" {
+		return fanOutPost(ctx, &post)
+	}
+	return invalidateFeedsContaining(ctx, []primitive.ObjectID{id})
+}
+
+// handleUserChange drops the caches a user's deletion or removal leaves stale, keeping a search
+// index with its own copy in step
+func handleUserChange(ctx context.Context, operation string, id primitive.ObjectID, doc bson.Raw) error {
+	indexer, hasIndexer := searchIndex.(SearchIndexer)
+
+	if doc != nil {
+		var user models.User
+		if err := bson.Unmarshal(doc, &user); err != nil {
+			return err
+		}
+		if user.DeletedAt == nil {
+			if hasIndexer {
+				return indexer.IndexUser(ctx, &user)
+			}
+			return nil
+		}
+	}
+	if hasIndexer {
+		if err := indexer.RemoveUser(ctx, id); err != nil {
+			return err
+		}
+	}
+
+	// Feeds holding the user's posts now show a deleted author
+	followers, err := followerIDs(ctx, id)
+	if err != nil {
+		return err
+	}
+	for _, followerID := range followers {
+		if err := invalidateFeedCache(ctx, followerID); err != nil {
+			return err
+		}
+	}
+	return deps.Redis.Del(ctx, suggestionsKey(id), unreadCountKey(id)).Err()
+}
+
+// fanOutPost adds a new post to the feeds of the author's followers who may see it. Celebrities'
+// posts are pulled in when their followers read their feeds, so they are left alone.
+func fanOutPost(ctx context.Context, post *models.Post) error {
+	var author models.User
+	err := usersCollection.FindOne(ctx, bson.M{"_id": post.UserID}, options.FindOne().SetProjection(bson.M{"is_celebrity": 1})).Decode(&author)
+	if err == mongo.ErrNoDocuments || (err == nil && author.IsCelebrity) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	audience, err := followerAudience(ctx, post)
+	if err != nil || len(audience) == 0 {
+		return err
+	}
+
+	_, err = feedsCollection.UpdateMany(
+		ctx,
+		bson.M{"user_id": bson.M{"$in": audience}},
+		bson.M{
+			"$addToSet": bson.M{"posts": post.ID},
+			"$set":      bson.M{"updated_at": time.Now()},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	for _, followerID := range audience {
+		if err := invalidateFeedCache(ctx, followerID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// followerAudience returns the author's followers who may see the post. Each follower gets a
+// viewer holding just the follows and blocks the post's visibility depends on.
+func followerAudience(ctx context.Context, post *models.Post) ([]primitive.ObjectID, error) {
+	followers, err := followerIDs(ctx, post.UserID)
+	if err != nil || len(followers) == 0 {
+		return nil, err
+	}
+
+	// Besides the author, a repost's visibility depends on the original author
+	related := append([]primitive.ObjectID{post.UserID}, post.PrivateTo...)
+	if post.RepostOfUserID != nil {
+		related = append(related, *post.RepostOfUserID)
+	}
+
+	viewers := make(map[primitive.ObjectID]*visibility.Viewer, len(followers))
+	for _, id := range followers {
+		viewer := visibility.Anonymous()
+		viewer.ID = id
+		viewer.Following[post.UserID] = true
+		viewers[id] = viewer
+	}
+
+	var edges []models.Follow
+	cursor, err := followsCollection.Find(ctx, bson.M{
+		"follower_id": bson.M{"$in": followers},
+		"followee_id": bson.M{"$in": related},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &edges); err != nil {
+		return nil, err
+	}
+	for _, edge := range edges {
+		viewers[edge.FollowerID].Following[edge.FolloweeID] = true
+	}
+
+	var blocks []models.Block
+	cursor, err = blocksCollection.Find(ctx, bson.M{"$or": []bson.M{
+		{"blocker_id": bson.M{"$in": followers}, "blocked_id": bson.M{"$in": related}},
+		{"blocked_id": bson.M{"$in": followers}, "blocker_id": bson.M{"$in": related}},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &blocks); err != nil {
+		return nil, err
+	}
+	for _, block := range blocks {
+		if viewer, ok := viewers[block.BlockerID]; ok {
+			viewer.Blocked[block.BlockedID] = true
+		}
+		if viewer, ok := viewers[block.BlockedID]; ok {
+			viewer.Blocked[block.BlockerID] = true
+		}
+	}
+
+	audience := make([]primitive.ObjectID, 0, len(followers))
+	for _, id := range followers {
+		if viewers[id].CanSee(post) {
+			audience = append(audience, id)
+		}
+	}
+	return audience, nil
+}
+
+// followerIDs returns the IDs of every user following the given user
+func followerIDs(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	cursor, err := followsCollection.Find(
+		ctx,
+		bson.M{"followee_id": userID},
+		options.Find().SetProjection(bson.M{"follower_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	ids := make([]primitive.ObjectID, 0)
+	for cursor.Next(ctx) {
+		var edge models.Follow
+		if err := cursor.Decode(&edge); err != nil {
+			return nil, err
+		}
+		ids = append(ids, edge.FollowerID)
+	}
+	return ids, cursor.Err()
+}
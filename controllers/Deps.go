@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"feed/initializers"
+	"feed/webhooks"
+
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Deps holds what the handlers and background jobs share: the clients they talk to and the
+// settings read from the environment. Every command builds it once with LoadDeps and installs
+// it with Configure before serving or starting jobs.
+type Deps struct {
+	// Mongo is the client the collections are opened on
+	Mongo *mongo.Client
+	Redis *redis.Client
+	Settings
+}
+
+// Settings are the tunables read from the environment
+type Settings struct {
+	// PostRetention is how long a soft deleted post can be restored before it is purged
+	PostRetention time.Duration
+	// PostEditWindow is how long after creation a post can still be edited, zero means forever
+	PostEditWindow time.Duration
+	// UsernameRedirectGrace is how long an old handle keeps pointing at its user after a rename
+	UsernameRedirectGrace time.Duration
+	// SuggestionTTL is how long precomputed suggestions are served, the builder refreshes them well before
+	SuggestionTTL time.Duration
+	// TagCountTTL is how long tag counts are cached before they are counted again
+	TagCountTTL time.Duration
+	// TrendingMinTagAuthors is how many different authors must use a tag within the window for it to trend
+	TrendingMinTagAuthors int
+	// TrendingMaxPostsPerAuthor caps how many of one author's posts appear in trending posts
+	TrendingMaxPostsPerAuthor int
+	// Users are promoted to pull mode at CelebrityPromoteThreshold followers and only demoted
+	// again below CelebrityDemoteThreshold, so a count hovering around one value doesn't flap
+	CelebrityPromoteThreshold int
+	CelebrityDemoteThreshold  int
+	// WebhookMaxAttempts is how many times a delivery is tried before it goes to the dead letters
+	WebhookMaxAttempts int
+	// WebhookTimeout is how long receivers get to answer a delivery
+	WebhookTimeout time.Duration
+	// AccountDeletionMaxAttempts is how many times a deletion job is run before it is marked failed
+	AccountDeletionMaxAttempts int
+}
+
+// LoadSettings reads the settings from the environment, using the defaults for unset ones
+func LoadSettings() Settings {
+	return Settings{
+		PostRetention:              initializers.EnvDuration("POST_RETENTION", 30*24*time.Hour),
+		PostEditWindow:             initializers.EnvDuration("POST_EDIT_WINDOW", 0),
+		UsernameRedirectGrace:      initializers.EnvDuration("USERNAME_REDIRECT_GRACE", 30*24*time.Hour),
+		SuggestionTTL:              initializers.EnvDuration("SUGGESTION_TTL", 24*time.Hour),
+		TagCountTTL:                initializers.EnvDuration("TAG_COUNT_TTL", 5*time.Minute),
+		TrendingMinTagAuthors:      initializers.EnvInt("TRENDING_MIN_TAG_AUTHORS", 3),
+		TrendingMaxPostsPerAuthor:  initializers.EnvInt("TRENDING_MAX_POSTS_PER_AUTHOR", 2),
+		CelebrityPromoteThreshold:  initializers.EnvInt("CELEBRITY_PROMOTE_THRESHOLD", 10000),
+		CelebrityDemoteThreshold:   initializers.EnvInt("CELEBRITY_DEMOTE_THRESHOLD", 8000),
+		WebhookMaxAttempts:         initializers.EnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		WebhookTimeout:             initializers.EnvDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		AccountDeletionMaxAttempts: initializers.EnvInt("ACCOUNT_DELETION_MAX_ATTEMPTS", 5),
+	}
+}
+
+// LoadDeps connects to Redis and reads the settings from the environment
+func LoadDeps() *Deps {
+	return &Deps{
+		Mongo:    initializers.Client,
+		Redis:    initializers.OpenRedis(),
+		Settings: LoadSettings(),
+	}
+}
+
+// deps is what Configure installed
+var deps *Deps
+
+// webhookSender makes the webhook HTTP calls, Configure sets its timeout
+var webhookSender = &webhooks.Sender{}
+
+// Configure installs the dependencies the handlers and jobs use
+func Configure(d *Deps) {
+	deps = d
+	webhookSender.Client = &http.Client{Timeout: d.WebhookTimeout}
+}
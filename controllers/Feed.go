@@ -10,9 +10,9 @@ import (
 
 	"feed/initializers"
 	"feed/models"
+	"feed/visibility"
 
 	"github.com/gin-gonic/gin"
-	"github.com/go-redis/redis/v8"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -20,7 +20,6 @@ import (
 )
 
 var feedsCollection *mongo.Collection = initializers.OpenCollection(initializers.Client, "feed")
-var redisClient *redis.Client = initializers.OpenRedis()
 
 func GetFeed(c *gin.Context) {
 	// Get userID from params and handle invalid ObjectID
@@ -32,11 +31,11 @@ func GetFeed(c *gin.Context) {
 
 	// Parse pagination parameters
 	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
-	if err != nil {
+	if err != nil || page < 1 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page number"})
 		return
 	}
-	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	limit, err := parseLimit(c, 10, 100)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit number"})
 		return
@@ -45,7 +44,7 @@ func GetFeed(c *gin.Context) {
 
 	// Check Redis cache first
 	cacheKey := fmt.Sprintf("feed:%s:%d:%d", userID.Hex(), page, limit)
-	cachedFeed, err := redisClient.Get(context.Background(), cacheKey).Result()
+	cachedFeed, err := deps.Redis.Get(context.Background(), cacheKey).Result()
 
 	if err == nil {
 		// Cache hit: deserialize cached feed
@@ -106,23 +105,45 @@ func GetFeed(c *gin.Context) {
 		}
 	}
 
+	// Celebrities are not fanned out, their posts are pulled in when the feed is read
+	followees, err := followingIDs(context.Background(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve follows"})
+		return
+	}
+	_, celebrities, err := splitByCelebrity(context.Background(), followees)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve follows"})
+		return
+	}
+	tags, err := followedTags(context.Background(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve followed tags"})
+		return
+	}
+	source := feedSource(&feed, celebrities, tags)
+
+	// Blocks and mutes apply to the feed's owner
+	viewer, err := loadViewer(context.Background(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load viewer"})
+		return
+	}
+	if err = loadMutes(context.Background(), viewer); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load mutes"})
+		return
+	}
+
 	// Fetch posts from the feed
-	var posts []models.Post
-	cursor, err := postsCollection.Find(
-		context.Background(),
-		bson.M{
-			"_id": bson.M{"$in": feed.Posts},
-		},
-		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetSkip(int64(skip)).SetLimit(int64(limit)),
-	)
+	posts, err := collectFeedPage(context.Background(), source, viewer, skip, limit)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve posts"})
 		return
 	}
-	defer cursor.Close(context.Background())
 
-	if err = cursor.All(context.Background(), &posts); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode posts"})
+	// Attach reposted originals and who reposted them
+	if err = hydrateReposts(context.Background(), source, viewer, posts); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load reposts"})
 		return
 	}
 
@@ -134,7 +155,7 @@ func GetFeed(c *gin.Context) {
 		return
 	}
 
-	err = redisClient.Set(context.Background(), cacheKey, serializedPosts, 10*time.Minute).Err() // 10-minute cache expiry
+	err = deps.Redis.Set(context.Background(), cacheKey, serializedPosts, 10*time.Minute).Err() // 10-minute cache expiry
 	if err != nil {
 		fmt.Println("Error caching feed:", err)
 	}
@@ -157,22 +178,24 @@ func updateFeed(userID primitive.ObjectID, feed *models.Feed) error {
 	}
 
 	// Get posts from non-celebrity users that the current user is following
-	followingIDs := make([]primitive.ObjectID, 0)
-	for _, id := range user.Following {
-		var followedUser models.User
-		err := usersCollection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&followedUser)
-		if err == nil && !followedUser.IsCelebrity {
-			followingIDs = append(followingIDs, id)
-		}
+	viewer, err := loadViewer(context.Background(), userID)
+	if err != nil {
+		return err
+	}
+	authorIDs, _, err := splitByCelebrity(context.Background(), viewer.FollowingIDs())
+	if err != nil {
+		return err
 	}
 
-	// Query new posts based on the following users
+	// Query new posts based on the following users. Reposts are posts of the reposter and fan out
+	// the same way, duplicates of the same original are collapsed when the feed is read.
+	// Posts the user is not in the audience of never enter the feed.
 	cursor, err := postsCollection.Find(
 		context.Background(),
-		bson.M{
-			"user_id":    bson.M{"$in": followingIDs},
+		notDeleted(visibleTo(viewer, bson.M{
+			"user_id":    bson.M{"$in": authorIDs},
 			"created_at": bson.M{"$gt": user.LastFeedUpdate},
-		},
+		})),
 		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}),
 	)
 	if err != nil {
@@ -185,10 +208,16 @@ func updateFeed(userID primitive.ObjectID, feed *models.Feed) error {
 		return err
 	}
 
-	// Update feed with new posts
-	newPostIDs := make([]primitive.ObjectID, len(newPosts))
-	for i, post := range newPosts {
-		newPostIDs[i] = post.ID
+	// Update feed with new posts, skipping those the change stream worker already fanned out
+	inFeed := make(map[primitive.ObjectID]bool, len(feed.Posts))
+	for _, id := range feed.Posts {
+		inFeed[id] = true
+	}
+	newPostIDs := make([]primitive.ObjectID, 0, len(newPosts))
+	for _, post := range newPosts {
+		if !inFeed[post.ID] {
+			newPostIDs = append(newPostIDs, post.ID)
+		}
 	}
 	feed.Posts = append(newPostIDs, feed.Posts...)
 	feed.UpdatedAt = time.Now()
@@ -215,3 +244,263 @@ func updateFeed(userID primitive.ObjectID, feed *models.Feed) error {
 	)
 	return err
 }
+
+// splitByCelebrity separates users whose posts are fanned out into their followers' feeds
+// from celebrities, whose posts followers pull in when reading their feed
+func splitByCelebrity(ctx context.Context, userIDs []primitive.ObjectID) ([]primitive.ObjectID, []primitive.ObjectID, error) {
+	cursor, err := usersCollection.Find(
+		ctx,
+		bson.M{"_id": bson.M{"$in": userIDs}},
+		options.Find().SetProjection(bson.M{"_id": 1, "is_celebrity": 1}),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	var users []models.User
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, nil, err
+	}
+
+	regular := make([]primitive.ObjectID, 0, len(users))
+	celebrities := make([]primitive.ObjectID, 0)
+	for _, user := range users {
+		if user.IsCelebrity {
+			celebrities = append(celebrities, user.ID)
+		} else {
+			regular = append(regular, user.ID)
+		}
+	}
+	return regular, celebrities, nil
+}
+
+// feedSource matches every post that belongs in a feed: the ones fanned out into it, the ones
+// written by the celebrities its owner follows, the ones carrying a tag the owner follows and
+// mentioned-only posts naming the owner, whose authors they may not follow.
+// A post matching several of these is still one document, so it is only listed once.
+func feedSource(feed *models.Feed, celebrities []primitive.ObjectID, tags []string) bson.M {
+	sources := []bson.M{
+		{"_id": bson.M{"$in": feed.Posts}},
+		{"user_id": bson.M{"$in": celebrities}},
+		{"visibility": models.VisibilityMentioned, "mentions": feed.UserID},
+	}
+	if len(tags) > 0 {
+		sources = append(sources, bson.M{"tags": bson.M{"$in": tags}})
+	}
+	return bson.M{"$or": sources}
+}
+
+// feedEntryKey identifies what a feed entry shows. Plain reposts share the key of their
+// original so the same post only appears once however many followees reposted it.
+func feedEntryKey(post *models.Post) primitive.ObjectID {
+	if post.IsRepost() {
+		return *post.RepostOf
+	}
+	return post.ID
+}
+
+// feedScanFactor bounds how many posts collectFeedPage reads per entry it returns, so a feed
+// pulling in busy celebrities or tags never walks their whole history
+const feedScanFactor = 4
+
+// collectFeedPage walks the posts matching source newest first, skipping entries already shown,
+// and returns one page of de-duplicated posts. Pagination counts entries rather than raw posts
+// so collapsed reposts and muted posts don't cause short pages, unless more than
+// feedScanFactor posts per entry had to be skipped.
+func collectFeedPage(ctx context.Context, source bson.M, viewer *visibility.Viewer, skip, limit int) ([]models.Post, error) {
+	cursor, err := postsCollection.Find(
+		ctx,
+		notDeleted(visibleTo(viewer, source)),
+		options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: -1}}).
+			SetLimit(int64((skip+limit)*feedScanFactor)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []models.Post
+	if err = cursor.All(ctx, &candidates); err != nil {
+		return nil, err
+	}
+	originals, err := mutedOriginals(ctx, viewer, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	posts := make([]models.Post, 0)
+	seen := make(map[primitive.ObjectID]bool)
+	position := 0
+	for i := 0; len(posts) < limit && i < len(candidates); i++ {
+		post := candidates[i]
+		key := feedEntryKey(&post)
+		if seen[key] || !viewer.CanSee(&post) {
+			continue
+		}
+		var original *models.Post
+		if post.RepostOf != nil {
+			original = originals[*post.RepostOf]
+		}
+		if viewer.Mutes.Hides(&post, original) {
+			continue
+		}
+		seen[key] = true
+
+		if position < skip {
+			position++
+			continue
+		}
+		posts = append(posts, post)
+	}
+	return posts, nil
+}
+
+// mutedOriginals loads the posts the reposts point at when the viewer has content mutes,
+// since a plain repost carries none of the original's text or tags
+func mutedOriginals(ctx context.Context, viewer *visibility.Viewer, posts []models.Post) (map[primitive.ObjectID]*models.Post, error) {
+	originals := make(map[primitive.ObjectID]*models.Post)
+	if !viewer.Mutes.MutesContent() {
+		return originals, nil
+	}
+	ids := make([]primitive.ObjectID, 0)
+	for _, post := range posts {
+		if post.RepostOf != nil {
+			ids = append(ids, *post.RepostOf)
+		}
+	}
+	if len(ids) == 0 {
+		return originals, nil
+	}
+
+	var found []models.Post
+	cursor, err := postsCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	for i := range found {
+		originals[found[i].ID] = &found[i]
+	}
+	return originals, nil
+}
+
+// hydrateReposts inlines the original of every repost and quote post in the page and
+// attributes plain reposts to the followees who made them
+func hydrateReposts(ctx context.Context, source bson.M, viewer *visibility.Viewer, posts []models.Post) error {
+	originalIDs := make([]primitive.ObjectID, 0)
+	keys := make([]primitive.ObjectID, 0, len(posts))
+	for i := range posts {
+		if posts[i].RepostOf != nil {
+			originalIDs = append(originalIDs, *posts[i].RepostOf)
+		}
+		keys = append(keys, feedEntryKey(&posts[i]))
+	}
+	if len(originalIDs) == 0 {
+		return nil
+	}
+
+	// Load the originals
+	var originals []models.Post
+	cursor, err := postsCollection.Find(ctx, notDeleted(bson.M{"_id": bson.M{"$in": originalIDs}}))
+	if err != nil {
+		return err
+	}
+	if err = cursor.All(ctx, &originals); err != nil {
+		return err
+	}
+	originalsByID := make(map[primitive.ObjectID]*models.Post, len(originals))
+	for i := range originals {
+		if viewer.CanSee(&originals[i]) {
+			originalsByID[originals[i].ID] = &originals[i]
+		}
+	}
+
+	// Find every plain repost of the page's entries that made it into this feed
+	var reposts []models.Post
+	cursor, err = postsCollection.Find(
+		ctx,
+		notDeleted(visibleTo(viewer, bson.M{"$and": []bson.M{
+			source,
+			{"repost_of": bson.M{"$in": keys}, "content": ""},
+		}})),
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}),
+	)
+	if err != nil {
+		return err
+	}
+	if err = cursor.All(ctx, &reposts); err != nil {
+		return err
+	}
+
+	reposterIDs := make([]primitive.ObjectID, 0, len(reposts))
+	for _, repost := range reposts {
+		reposterIDs = append(reposterIDs, repost.UserID)
+	}
+	var reposters []models.User
+	cursor, err = usersCollection.Find(ctx, bson.M{"_id": bson.M{"$in": reposterIDs}})
+	if err != nil {
+		return err
+	}
+	if err = cursor.All(ctx, &reposters); err != nil {
+		return err
+	}
+	usernames := make(map[primitive.ObjectID]string, len(reposters))
+	for _, reposter := range reposters {
+		usernames[reposter.ID] = reposter.Username
+	}
+
+	repostedBy := make(map[primitive.ObjectID][]string)
+	for _, repost := range reposts {
+		if username, ok := usernames[repost.UserID]; ok {
+			repostedBy[*repost.RepostOf] = append(repostedBy[*repost.RepostOf], username)
+		}
+	}
+
+	for i := range posts {
+		if posts[i].RepostOf != nil {
+			posts[i].Original = originalsByID[*posts[i].RepostOf]
+		}
+		posts[i].RepostedBy = repostedBy[feedEntryKey(&posts[i])]
+	}
+	return nil
+}
+
+// invalidateFeedCache drops every cached page of the user's feed
+func invalidateFeedCache(ctx context.Context, userID primitive.ObjectID) error {
+	var keys []string
+	iter := deps.Redis.Scan(ctx, 0, fmt.Sprintf("feed:%s:*", userID.Hex()), 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return deps.Redis.Del(ctx, keys...).Err()
+}
+
+// invalidateFeedsContaining drops the cached feed of every user whose feed holds one of the posts
+func invalidateFeedsContaining(ctx context.Context, postIDs []primitive.ObjectID) error {
+	cursor, err := feedsCollection.Find(
+		ctx,
+		bson.M{"posts": bson.M{"$in": postIDs}},
+		options.Find().SetProjection(bson.M{"user_id": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var feed models.Feed
+		if err := cursor.Decode(&feed); err != nil {
+			return err
+		}
+		if err := invalidateFeedCache(ctx, feed.UserID); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
@@ -0,0 +1,423 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"feed/initializers"
+	"feed/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var followsCollection *mongo.Collection = initializers.OpenCollection(initializers.Client, "follows")
+
+// Follow graph errors returned from inside follow transactions
+var (
+	errFollowerNotFound = errors.New("follower not found")
+	errFolloweeNotFound = errors.New("followee not found")
+	errAlreadyFollowing = errors.New("already following user")
+	errNotFollowing     = errors.New("not following user")
+)
+
+// FollowUser adds a follow edge. The edge and both counts are written in one transaction.
+// Following a private account only files a request, the edge is added once it is approved.
+func FollowUser(c *gin.Context) {
+	followerID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid follower ID"})
+		return
+	}
+	followeeID, err := primitive.ObjectIDFromHex(c.Param("followeeID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid followee ID"})
+		return
+	}
+	if followerID == followeeID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Users cannot follow themselves"})
+		return
+	}
+
+	requested := false
+	err = withTransaction(c.Request.Context(), func(sc mongo.SessionContext) error {
+		if err := checkFollowPair(sc, followerID, followeeID); err != nil {
+			return err
+		}
+		blocked, err := isBlocked(sc, followerID, followeeID)
+		if err != nil {
+			return err
+		}
+		if blocked {
+			return errBlocked
+		}
+		requested, err = isPrivateUser(sc, followeeID)
+		if err != nil {
+			return err
+		}
+		if requested {
+			return addFollowRequest(sc, followerID, followeeID)
+		}
+		if err := addFollowEdge(sc, followerID, followeeID); err != nil {
+			return err
+		}
+		return recordEvent(sc, WebhookUserFollowed, gin.H{"follower_id": followerID, "followee_id": followeeID})
+	})
+	if err != nil {
+		respondFollowError(c, err, "Failed to follow user")
+		return
+	}
+	kind := models.NotificationFollow
+	if requested {
+		kind = models.NotificationFollowRequest
+	}
+	if err = notify(c.Request.Context(), models.Notification{UserID: followeeID, Type: kind, ActorID: followerID}); err != nil {
+		fmt.Println("Error sending follow notification:", err)
+	}
+	if requested {
+		c.JSON(http.StatusAccepted, gin.H{"message": "Follow request sent"})
+		return
+	}
+
+	// The followee may have crossed a celebrity threshold
+	if err = applyCelebrityPolicy(c.Request.Context(), bson.M{"_id": followeeID}); err != nil {
+		fmt.Println("Error applying celebrity policy:", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Successfully followed user"})
+}
+
+// UnfollowUser removes a follow edge and updates both counts in one transaction.
+// Without an edge it withdraws a pending follow request instead.
+func UnfollowUser(c *gin.Context) {
+	followerID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid follower ID"})
+		return
+	}
+	followeeID, err := primitive.ObjectIDFromHex(c.Param("followeeID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid followee ID"})
+		return
+	}
+
+	withdrawn := false
+	err = withTransaction(c.Request.Context(), func(sc mongo.SessionContext) error {
+		if err := checkFollowPair(sc, followerID, followeeID); err != nil {
+			return err
+		}
+		err := removeFollowEdge(sc, followerID, followeeID)
+		if err != errNotFollowing {
+			return err
+		}
+		result, err := followRequestsCollection.DeleteOne(sc, bson.M{"requester_id": followerID, "target_id": followeeID})
+		if err != nil {
+			return err
+		}
+		if result.DeletedCount == 0 {
+			return errNotFollowing
+		}
+		withdrawn = true
+		return nil
+	})
+	if err != nil {
+		respondFollowError(c, err, "Failed to unfollow user")
+		return
+	}
+	if withdrawn {
+		c.JSON(http.StatusOK, gin.H{"message": "Follow request withdrawn"})
+		return
+	}
+
+	// The followee may have crossed a celebrity threshold
+	if err = applyCelebrityPolicy(c.Request.Context(), bson.M{"_id": followeeID}); err != nil {
+		fmt.Println("Error applying celebrity policy:", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Successfully unfollowed user"})
+}
+
+// addFollowEdge inserts the edge and bumps both counts. Call it inside a transaction.
+func addFollowEdge(sc mongo.SessionContext, followerID, followeeID primitive.ObjectID) error {
+	_, err := followsCollection.InsertOne(sc, models.Follow{
+		FollowerID: followerID,
+		FolloweeID: followeeID,
+		CreatedAt:  time.Now(),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return errAlreadyFollowing
+	} else if err != nil {
+		return err
+	}
+
+	return updateFollowCounts(sc, followerID, followeeID, 1)
+}
+
+// removeFollowEdge deletes the edge and lowers both counts. Call it inside a transaction.
+func removeFollowEdge(sc mongo.SessionContext, followerID, followeeID primitive.ObjectID) error {
+	result, err := followsCollection.DeleteOne(sc, bson.M{"follower_id": followerID, "followee_id": followeeID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errNotFollowing
+	}
+
+	return updateFollowCounts(sc, followerID, followeeID, -1)
+}
+
+// updateFollowCounts moves the denormalized counts on both ends of an edge by delta
+func updateFollowCounts(ctx context.Context, followerID, followeeID primitive.ObjectID, delta int) error {
+	_, err := usersCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": followeeID},
+		bson.M{"$inc": bson.M{"follower_count": delta}},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = usersCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": followerID},
+		bson.M{"$inc": bson.M{"following_count": delta}},
+	)
+	return err
+}
+
+// checkFollowPair makes sure both users exist
+func checkFollowPair(ctx context.Context, followerID, followeeID primitive.ObjectID) error {
+	count, err := usersCollection.CountDocuments(ctx, notDeleted(bson.M{"_id": followerID}))
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errFollowerNotFound
+	}
+
+	count, err = usersCollection.CountDocuments(ctx, notDeleted(bson.M{"_id": followeeID}))
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errFolloweeNotFound
+	}
+	return nil
+}
+
+// respondFollowError maps follow graph errors to their status codes
+func respondFollowError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, errFollowerNotFound), errors.Is(err, errFolloweeNotFound), errors.Is(err, errNotFollowing):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, errAlreadyFollowing), errors.Is(err, errAlreadyRequested):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, errBlocked):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message, "details": err.Error()})
+	}
+}
+
+// followingIDs returns the IDs of every user the given user follows
+func followingIDs(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	cursor, err := followsCollection.Find(
+		ctx,
+		bson.M{"follower_id": userID},
+		options.Find().SetProjection(bson.M{"followee_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	ids := make([]primitive.ObjectID, 0)
+	for cursor.Next(ctx) {
+		var edge models.Follow
+		if err := cursor.Decode(&edge); err != nil {
+			return nil, err
+		}
+		ids = append(ids, edge.FolloweeID)
+	}
+	return ids, cursor.Err()
+}
+
+// ListFollowers pages through the users following a user, most recent first
+func ListFollowers(c *gin.Context) {
+	listFollowEdges(c, "followee_id", "follower_id", func(edge models.Follow) primitive.ObjectID { return edge.FollowerID })
+}
+
+// ListFollowing pages through the users a user follows, most recent first
+func ListFollowing(c *gin.Context) {
+	listFollowEdges(c, "follower_id", "followee_id", func(edge models.Follow) primitive.ObjectID { return edge.FolloweeID })
+}
+
+// listFollowEdges serves one page of the edges whose ownerField is the user in the path,
+// hydrated into summaries of the user in otherField. Users blocked either way are left out,
+// and a private account's lists are only shown to itself and its followers.
+func listFollowEdges(c *gin.Context, ownerField, otherField string, other func(models.Follow) primitive.ObjectID) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	limit, err := parseLimit(c, 20, 100)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit number"})
+		return
+	}
+	filter, err := cursorFilter(c.Query("cursor"), "created_at")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+	viewer, err := requestViewer(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load viewer"})
+		return
+	}
+	filter[ownerField] = userID
+	filter[otherField] = bson.M{"$nin": viewer.BlockedIDs()}
+
+	var user models.User
+	err = usersCollection.FindOne(
+		c.Request.Context(),
+		notDeleted(bson.M{"_id": userID}),
+		options.FindOne().SetProjection(bson.M{"is_private": 1}),
+	).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		return
+	}
+	if !viewer.CanSeeUser(userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if !viewer.CanSeeConnections(&user) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This account is private"})
+		return
+	}
+
+	// Fetch one extra edge to know whether there is a next page
+	var edges []models.Follow
+	cursor, err := followsCollection.Find(
+		c.Request.Context(),
+		filter,
+		options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+			SetLimit(int64(limit+1)),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve follows"})
+		return
+	}
+	if err = cursor.All(c.Request.Context(), &edges); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode follows"})
+		return
+	}
+
+	nextCursor := ""
+	if len(edges) > limit {
+		edges = edges[:limit]
+		last := edges[len(edges)-1]
+		nextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+
+	ids := make([]primitive.ObjectID, len(edges))
+	for i, edge := range edges {
+		ids[i] = other(edge)
+	}
+	summaries, err := userSummaries(c.Request.Context(), ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve users"})
+		return
+	}
+	if !viewer.IsAnonymous() {
+		if err = setRelationshipFlags(c.Request.Context(), viewer.ID, summaries); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve relationships"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":       summaries,
+		"next_cursor": nextCursor,
+	})
+}
+
+// userSummaries loads the users in the order given, leaving out any that no longer exist
+func userSummaries(ctx context.Context, ids []primitive.ObjectID) ([]models.UserSummary, error) {
+	var users []models.User
+	cursor, err := usersCollection.Find(ctx, notDeleted(bson.M{"_id": bson.M{"$in": ids}}))
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	usersByID := make(map[primitive.ObjectID]models.User, len(users))
+	for _, user := range users {
+		usersByID[user.ID] = user
+	}
+
+	summaries := make([]models.UserSummary, 0, len(ids))
+	for _, id := range ids {
+		if user, ok := usersByID[id]; ok {
+			summaries = append(summaries, models.UserSummary{
+				ID:          user.ID,
+				Username:    user.Username,
+				Bio:         user.Bio,
+				IsCelebrity: user.IsCelebrity,
+				IsPrivate:   user.IsPrivate,
+			})
+		}
+	}
+	return summaries, nil
+}
+
+// setRelationshipFlags marks which of the summarized users the viewer follows and is followed by
+func setRelationshipFlags(ctx context.Context, viewer primitive.ObjectID, summaries []models.UserSummary) error {
+	ids := make([]primitive.ObjectID, len(summaries))
+	for i, summary := range summaries {
+		ids[i] = summary.ID
+	}
+
+	var edges []models.Follow
+	cursor, err := followsCollection.Find(ctx, bson.M{"$or": []bson.M{
+		{"follower_id": viewer, "followee_id": bson.M{"$in": ids}},
+		{"follower_id": bson.M{"$in": ids}, "followee_id": viewer},
+	}})
+	if err != nil {
+		return err
+	}
+	if err = cursor.All(ctx, &edges); err != nil {
+		return err
+	}
+
+	followedByMe := make(map[primitive.ObjectID]bool)
+	followsMe := make(map[primitive.ObjectID]bool)
+	for _, edge := range edges {
+		if edge.FollowerID == viewer {
+			followedByMe[edge.FolloweeID] = true
+		} else {
+			followsMe[edge.FollowerID] = true
+		}
+	}
+
+	for i := range summaries {
+		followed := followedByMe[summaries[i].ID]
+		follows := followsMe[summaries[i].ID]
+		summaries[i].FollowedByMe = &followed
+		summaries[i].FollowsMe = &follows
+	}
+	return nil
+}
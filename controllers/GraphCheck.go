@@ -0,0 +1,414 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"feed/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// graphCheckBatchSize is how many documents the checker resolves per lookup
+const graphCheckBatchSize = 500
+
+// maxGraphCheckSamples caps how many individual problems a report lists
+const maxGraphCheckSamples = 100
+
+// GraphReport summarizes the problems found in the follow graph and feeds
+type GraphReport struct {
+	Repair            bool     `json:"repair"`
+	EdgesScanned      int      `json:"edges_scanned"`
+	UsersScanned      int      `json:"users_scanned"`
+	FeedsScanned      int      `json:"feeds_scanned"`
+	DanglingEdges     int      `json:"dangling_edges"`
+	SelfFollows       int      `json:"self_follows"`
+	LegacyFollowLists int      `json:"legacy_follow_lists"`
+	CountMismatches   int      `json:"count_mismatches"`
+	OrphanedFeeds     int      `json:"orphaned_feeds"`
+	DanglingFeedPosts int      `json:"dangling_feed_posts"`
+	Samples           []string `json:"samples"`
+}
+
+// Issues is how many problems the check found
+func (r *GraphReport) Issues() int {
+	return r.DanglingEdges + r.SelfFollows + r.LegacyFollowLists +
+		r.CountMismatches + r.OrphanedFeeds + r.DanglingFeedPosts
+}
+
+// sample records one problem, up to maxGraphCheckSamples of them
+func (r *GraphReport) sample(format string, args ...interface{}) {
+	if len(r.Samples) < maxGraphCheckSamples {
+		r.Samples = append(r.Samples, fmt.Sprintf(format, args...))
+	}
+}
+
+// CheckGraph scans the follow graph and feeds for inconsistencies and fixes them when repair is set.
+// Edges are cleaned up before counts are recomputed so the counts reflect the repaired graph.
+func CheckGraph(ctx context.Context, repair bool) (*GraphReport, error) {
+	report := &GraphReport{Repair: repair, Samples: []string{}}
+
+	if err := checkFollowEdges(ctx, report); err != nil {
+		return report, fmt.Errorf("checking follow edges: %w", err)
+	}
+	if err := checkLegacyFollowLists(ctx, report); err != nil {
+		return report, fmt.Errorf("checking legacy follow lists: %w", err)
+	}
+	if err := checkFollowCounts(ctx, report); err != nil {
+		return report, fmt.Errorf("checking follow counts: %w", err)
+	}
+	if err := checkFeeds(ctx, report); err != nil {
+		return report, fmt.Errorf("checking feeds: %w", err)
+	}
+	return report, nil
+}
+
+// CheckGraphHandler runs the checker from the admin API. Pass repair=true to fix what it finds.
+func CheckGraphHandler(c *gin.Context) {
+	repair, err := strconv.ParseBool(c.DefaultQuery("repair", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid repair flag"})
+		return
+	}
+
+	report, err := CheckGraph(c.Request.Context(), repair)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Graph check failed", "details": err.Error(), "report": report})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// checkFollowEdges finds self follows and edges pointing at users that no longer exist
+func checkFollowEdges(ctx context.Context, report *GraphReport) error {
+	cursor, err := followsCollection.Find(ctx, bson.M{}, options.Find().SetBatchSize(graphCheckBatchSize))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	batch := make([]models.Follow, 0, graphCheckBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := checkFollowEdgeBatch(ctx, report, batch)
+		batch = batch[:0]
+		return err
+	}
+
+	for cursor.Next(ctx) {
+		var edge models.Follow
+		if err := cursor.Decode(&edge); err != nil {
+			return err
+		}
+		report.EdgesScanned++
+		batch = append(batch, edge)
+		if len(batch) == graphCheckBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+func checkFollowEdgeBatch(ctx context.Context, report *GraphReport, edges []models.Follow) error {
+	ids := make([]primitive.ObjectID, 0, len(edges)*2)
+	for _, edge := range edges {
+		ids = append(ids, edge.FollowerID, edge.FolloweeID)
+	}
+	existing, err := existingUserIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	broken := make([]primitive.ObjectID, 0)
+	for _, edge := range edges {
+		switch {
+		case edge.FollowerID == edge.FolloweeID:
+			report.SelfFollows++
+			report.sample("self follow %s", edge.FollowerID.Hex())
+		case !existing[edge.FollowerID] || !existing[edge.FolloweeID]:
+			report.DanglingEdges++
+			report.sample("dangling edge %s -> %s", edge.FollowerID.Hex(), edge.FolloweeID.Hex())
+		default:
+			continue
+		}
+		broken = append(broken, edge.ID)
+	}
+
+	if !report.Repair || len(broken) == 0 {
+		return nil
+	}
+	_, err = followsCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": broken}})
+	return err
+}
+
+// checkLegacyFollowLists finds users still carrying the embedded arrays that predate the follows collection.
+// Repair turns the entries that point at live users into edges and drops the arrays.
+func checkLegacyFollowLists(ctx context.Context, report *GraphReport) error {
+	cursor, err := usersCollection.Find(
+		ctx,
+		bson.M{"$or": []bson.M{
+			{"following": bson.M{"$exists": true}},
+			{"followers": bson.M{"$exists": true}},
+		}},
+		options.Find().SetProjection(bson.M{"following": 1, "followers": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var lists legacyFollowLists
+		if err := cursor.Decode(&lists); err != nil {
+			return err
+		}
+		report.LegacyFollowLists++
+		report.sample("user %s still has embedded follow lists", lists.ID.Hex())
+		if !report.Repair {
+			continue
+		}
+
+		existing, err := existingUserIDs(ctx, append(append([]primitive.ObjectID{}, lists.Following...), lists.Followers...))
+		if err != nil {
+			return err
+		}
+		for _, followeeID := range lists.Following {
+			if existing[followeeID] {
+				if err := upsertFollowEdge(ctx, lists.ID, followeeID); err != nil {
+					return err
+				}
+			}
+		}
+		for _, followerID := range lists.Followers {
+			if existing[followerID] {
+				if err := upsertFollowEdge(ctx, followerID, lists.ID); err != nil {
+					return err
+				}
+			}
+		}
+		_, err = usersCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": lists.ID},
+			bson.M{"$unset": bson.M{"following": "", "followers": ""}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// checkFollowCounts compares each user's denormalized counts with the edges
+func checkFollowCounts(ctx context.Context, report *GraphReport) error {
+	cursor, err := usersCollection.Find(
+		ctx,
+		bson.M{},
+		options.Find().SetProjection(bson.M{"follower_count": 1, "following_count": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+		report.UsersScanned++
+
+		followers, err := followsCollection.CountDocuments(ctx, bson.M{"followee_id": user.ID})
+		if err != nil {
+			return err
+		}
+		following, err := followsCollection.CountDocuments(ctx, bson.M{"follower_id": user.ID})
+		if err != nil {
+			return err
+		}
+		if int64(user.FollowerCount) == followers && int64(user.FollowingCount) == following {
+			continue
+		}
+
+		report.CountMismatches++
+		report.sample("user %s counts %d/%d, edges %d/%d",
+			user.ID.Hex(), user.FollowerCount, user.FollowingCount, followers, following)
+		if !report.Repair {
+			continue
+		}
+		if err := repairFollowCounts(ctx, user.ID); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// repairFollowCounts recounts the user's edges and stores the counts in one transaction, so a
+// follow committed in between can't leave the counts stale again
+func repairFollowCounts(ctx context.Context, userID primitive.ObjectID) error {
+	return withTransaction(ctx, func(sc mongo.SessionContext) error {
+		followers, err := followsCollection.CountDocuments(sc, bson.M{"followee_id": userID})
+		if err != nil {
+			return err
+		}
+		following, err := followsCollection.CountDocuments(sc, bson.M{"follower_id": userID})
+		if err != nil {
+			return err
+		}
+		_, err = usersCollection.UpdateOne(
+			sc,
+			bson.M{"_id": userID},
+			bson.M{"$set": bson.M{"follower_count": followers, "following_count": following}},
+		)
+		return err
+	})
+}
+
+// checkFeeds finds feeds whose owner is gone and feed entries pointing at posts that no longer exist
+func checkFeeds(ctx context.Context, report *GraphReport) error {
+	cursor, err := feedsCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var feed models.Feed
+		if err := cursor.Decode(&feed); err != nil {
+			return err
+		}
+		report.FeedsScanned++
+
+		existing, err := existingUserIDs(ctx, []primitive.ObjectID{feed.UserID})
+		if err != nil {
+			return err
+		}
+		if !existing[feed.UserID] {
+			report.OrphanedFeeds++
+			report.sample("orphaned feed %s of user %s", feed.ID.Hex(), feed.UserID.Hex())
+			if report.Repair {
+				if _, err := feedsCollection.DeleteOne(ctx, bson.M{"_id": feed.ID}); err != nil {
+					return err
+				}
+				if err := invalidateFeedCache(ctx, feed.UserID); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		dangling, err := missingPostIDs(ctx, feed.Posts)
+		if err != nil {
+			return err
+		}
+		if len(dangling) == 0 {
+			continue
+		}
+		report.DanglingFeedPosts += len(dangling)
+		report.sample("feed of user %s references %d missing posts", feed.UserID.Hex(), len(dangling))
+		if report.Repair {
+			_, err := feedsCollection.UpdateOne(
+				ctx,
+				bson.M{"_id": feed.ID},
+				bson.M{"$pull": bson.M{"posts": bson.M{"$in": dangling}}},
+			)
+			if err != nil {
+				return err
+			}
+			if err := invalidateFeedCache(ctx, feed.UserID); err != nil {
+				return err
+			}
+		}
+	}
+	return cursor.Err()
+}
+
+// existingUserIDs returns which of the IDs belong to users that exist. A user being deleted still
+// counts while the deletion job is open, the job removes their edges and feed itself.
+func existingUserIDs(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]bool, error) {
+	cursor, err := usersCollection.Find(
+		ctx,
+		bson.M{"_id": bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{"_id": 1, "deleted_at": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	existing := make(map[primitive.ObjectID]bool, len(users))
+	deleting := make([]primitive.ObjectID, 0)
+	for _, user := range users {
+		if user.DeletedAt == nil {
+			existing[user.ID] = true
+		} else {
+			deleting = append(deleting, user.ID)
+		}
+	}
+	if len(deleting) == 0 {
+		return existing, nil
+	}
+
+	var jobs []models.AccountDeletionJob
+	cursor, err = accountDeletionsCollection.Find(
+		ctx,
+		bson.M{
+			"user_id": bson.M{"$in": deleting},
+			"status":  bson.M{"$in": []string{DeletionPending, DeletionRunning}},
+		},
+		options.Find().SetProjection(bson.M{"user_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &jobs); err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		existing[job.UserID] = true
+	}
+	return existing, nil
+}
+
+// missingPostIDs returns the IDs that have no post document, soft deleted posts still count as present
+func missingPostIDs(ctx context.Context, ids []primitive.ObjectID) ([]primitive.ObjectID, error) {
+	cursor, err := postsCollection.Find(
+		ctx,
+		bson.M{"_id": bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var posts []models.Post
+	if err = cursor.All(ctx, &posts); err != nil {
+		return nil, err
+	}
+
+	present := make(map[primitive.ObjectID]bool, len(posts))
+	for _, post := range posts {
+		present[post.ID] = true
+	}
+	missing := make([]primitive.ObjectID, 0)
+	for _, id := range ids {
+		if !present[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing, nil
+}
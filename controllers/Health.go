@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// heartbeatGrace is how much longer than twice its interval a job's loop may take to come back
+// round before it counts as stuck
+const heartbeatGrace = time.Minute
+
+// jobHeartbeat is when a background job's loop last went round, and how long it may take to
+// come back round
+type jobHeartbeat struct {
+	At      time.Time
+	Timeout time.Duration
+}
+
+var (
+	heartbeatsMu sync.Mutex
+	heartbeats   = map[string]jobHeartbeat{}
+)
+
+// heartbeat records that the job's loop, which comes round every interval, is alive
+func heartbeat(job string, interval time.Duration) {
+	heartbeatsMu.Lock()
+	defer heartbeatsMu.Unlock()
+	heartbeats[job] = jobHeartbeat{At: time.Now(), Timeout: 2*interval + heartbeatGrace}
+}
+
+// Health reports whether MongoDB and Redis answer, with a 503 when either doesn't
+func Health(c *gin.Context) {
+	status, checks := dependencyChecks(c.Request.Context())
+	c.JSON(status, checks)
+}
+
+// WorkerHealth adds the background jobs to Health, reporting when each job's loop last went
+// round, with a 503 when one is overdue
+func WorkerHealth(c *gin.Context) {
+	status, checks := dependencyChecks(c.Request.Context())
+
+	jobs := gin.H{}
+	heartbeatsMu.Lock()
+	for job, beat := range heartbeats {
+		state := "ok"
+		if time.Since(beat.At) > beat.Timeout {
+			state = "stuck"
+			status = http.StatusServiceUnavailable
+		}
+		jobs[job] = gin.H{"status": state, "last_heartbeat": beat.At}
+	}
+	heartbeatsMu.Unlock()
+	checks["jobs"] = jobs
+
+	c.JSON(status, checks)
+}
+
+// dependencyChecks pings MongoDB and Redis
+func dependencyChecks(ctx context.Context) (int, gin.H) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	status := http.StatusOK
+	checks := gin.H{"mongo": "ok", "redis": "ok"}
+	if err := deps.Mongo.Ping(ctx, nil); err != nil {
+		status = http.StatusServiceUnavailable
+		checks["mongo"] = err.Error()
+	}
+	if err := deps.Redis.Ping(ctx).Err(); err != nil {
+		status = http.StatusServiceUnavailable
+		checks["redis"] = err.Error()
+	}
+	return status, checks
+}
@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"feed/entities"
+	"feed/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxMentionsPerPost caps how many distinct users one post can mention, and so notify
+const maxMentionsPerPost = 50
+
+// applyEntities parses the post's content, resolving mentions to users and merging hashtags
+// into its tags. Mentions of unknown users are left as plain text.
+func applyEntities(ctx context.Context, post *models.Post) error {
+	found := entities.Parse(post.Content)
+	usernames := entities.Usernames(found)
+	if len(usernames) > maxMentionsPerPost {
+		usernames = usernames[:maxMentionsPerPost]
+	}
+	userIDs, err := userIDsByUsername(ctx, usernames)
+	if err != nil {
+		return err
+	}
+
+	resolved := make([]models.Entity, 0, len(found))
+	mentions := make([]primitive.ObjectID, 0)
+	mentioned := make(map[primitive.ObjectID]bool)
+	for _, entity := range found {
+		if entity.Type == models.EntityMention {
+			userID, ok := userIDs[entities.NormalizeUsername(entity.Value)]
+			if !ok {
+				continue
+			}
+			entity.UserID = &userID
+			if !mentioned[userID] {
+				mentioned[userID] = true
+				mentions = append(mentions, userID)
+			}
+		}
+		resolved = append(resolved, entity)
+	}
+
+	post.Entities = resolved
+	post.Mentions = mentions
+	post.Tags = entities.MergeTags(post.Tags, entities.Hashtags(found))
+	return nil
+}
+
+// userIDsByUsername looks the usernames up by handle, keyed by the handle
+func userIDsByUsername(ctx context.Context, usernames []string) (map[string]primitive.ObjectID, error) {
+	ids := make(map[string]primitive.ObjectID, len(usernames))
+	if len(usernames) == 0 {
+		return ids, nil
+	}
+	handles := make([]string, len(usernames))
+	for i, username := range usernames {
+		handles[i] = entities.NormalizeUsername(username)
+	}
+
+	var users []models.User
+	cursor, err := usersCollection.Find(
+		ctx,
+		notDeleted(bson.M{"handle": bson.M{"$in": handles}}),
+		options.Find().SetProjection(bson.M{"handle": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	for _, user := range users {
+		ids[user.Handle] = user.ID
+	}
+	return ids, nil
+}
+
+// notifyMentions notifies the users the post mentions, except the author, those already
+// mentioned before an edit and those who may not see the post
+func notifyMentions(ctx context.Context, post *models.Post, previous []primitive.ObjectID) {
+	skip := make(map[primitive.ObjectID]bool, len(previous)+1)
+	skip[post.UserID] = true
+	for _, id := range previous {
+		skip[id] = true
+	}
+
+	for _, userID := range post.Mentions {
+		if skip[userID] {
+			continue
+		}
+		viewer, err := loadViewer(ctx, userID)
+		if err != nil {
+			fmt.Println("Error loading mentioned user:", err)
+			continue
+		}
+		if !viewer.CanSee(post) {
+			continue
+		}
+
+		postID := post.ID
+		err = notify(ctx, models.Notification{
+			UserID:  userID,
+			Type:    models.NotificationMention,
+			ActorID: post.UserID,
+			PostID:  &postID,
+		})
+		if err != nil {
+			fmt.Println("Error sending mention notification:", err)
+		}
+	}
+}
@@ -0,0 +1,500 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"feed/initializers"
+	"feed/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var migrationsCollection *mongo.Collection = initializers.OpenCollection(initializers.Client, "migration")
+
+// migration is a one-off data change. Migrations must be safe to re-run, since a crash
+// before one is recorded means it runs again from the start.
+type migration struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// migrations run in order, each one only once
+var migrations = []migration{
+	{"follow_graph_edges", migrateFollowGraph},
+	{"normalize_tags", migrateTagCase},
+	{"username_handles", migrateUsernames},
+	{"notification_groups", migrateNotificationGroups},
+	{"dedupe_plain_reposts", migrateDuplicateReposts},
+	{"repost_original_authors", migrateRepostAuthors},
+	{"drop_empty_tags", migrateEmptyTags},
+	{"notification_group_actors", migrateNotificationGroupActors},
+}
+
+// RunMigrations applies the migrations that have not run yet, then creates the indexes.
+// Migrations go first so they can clean up data a new unique index would reject.
+func RunMigrations(ctx context.Context) error {
+	for _, m := range migrations {
+		count, err := migrationsCollection.CountDocuments(ctx, bson.M{"_id": m.name})
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		fmt.Println("Running migration", m.name)
+		if err := m.run(ctx); err != nil {
+			return fmt.Errorf("migration %s: %w", m.name, err)
+		}
+		_, err = migrationsCollection.InsertOne(ctx, bson.M{"_id": m.name, "applied_at": time.Now()})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := EnsureIndexes(ctx); err != nil {
+		return fmt.Errorf("creating indexes: %w", err)
+	}
+	return nil
+}
+
+// EnsureIndexes creates the indexes the queries rely on. Creating an existing index is a no-op.
+func EnsureIndexes(ctx context.Context) error {
+	_, err := usersCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "handle", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"handle": bson.M{"$type": "string"}}),
+		},
+		// Popular accounts for suggestions
+		{Keys: bson.D{{Key: "follower_count", Value: -1}}},
+		{
+			// Serves user search
+			Keys:    bson.D{{Key: "username", Value: "text"}, {Key: "bio", Value: "text"}},
+			Options: options.Index().SetName("user_text").SetWeights(bson.M{"username": 3, "bio": 1}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = usernameRedirectsCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "handle", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = followsCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "follower_id", Value: 1}, {Key: "followee_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "follower_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "followee_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = blocksCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "blocker_id", Value: 1}, {Key: "blocked_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "blocked_id", Value: 1}}},
+		{Keys: bson.D{{Key: "blocker_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = followRequestsCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "requester_id", Value: 1}, {Key: "target_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "target_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = notificationsCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "updated_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{
+			// One open group per kind, notify relies on it to not open a second one concurrently
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "group_key", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"group_key": bson.M{"$type": "string"}}),
+		},
+		{Keys: bson.D{{Key: "actor_ids", Value: 1}}},
+		{Keys: bson.D{{Key: "post_id", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = webhookSubscriptionsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "events", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = outboxCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "sent_at", Value: 1}, {Key: "created_at", Value: 1}}},
+		{
+			// Unsent entries have no sent_at and never expire
+			Keys:    bson.D{{Key: "sent_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(outboxRetention.Seconds())),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = webhookDeliveriesCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			// An event is delivered once per subscription however often it is read from the stream
+			Keys:    bson.D{{Key: "event_id", Value: 1}, {Key: "subscription_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{Keys: bson.D{{Key: "subscription_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{
+			// Keeps the delivery log to webhookLogRetention, dead letters are kept until handled
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(webhookLogRetention.Seconds())),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = postsCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tags", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		// Mentioned-only posts pulled into the mentioned users' feeds
+		{Keys: bson.D{{Key: "mentions", Value: 1}, {Key: "created_at", Value: -1}}},
+		{
+			// One live plain repost per user and original. Deleted ones differ in deleted_at, so
+			// undoing a repost doesn't stop the user reposting again.
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "repost_of", Value: 1}, {Key: "deleted_at", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"repost_of": bson.M{"$exists": true}, "content": ""}),
+		},
+		{
+			// Serves search, a collection can only have one text index
+			Keys:    bson.D{{Key: "content", Value: "text"}, {Key: "tags", Value: "text"}},
+			Options: options.Index().SetName("post_text").SetWeights(bson.M{"content": 1, "tags": 3}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = likesCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			// A user likes a post once
+			Keys:    bson.D{{Key: "post_id", Value: 1}, {Key: "user_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = feedsCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		// Finds the feeds holding a post when it changes or goes away
+		{Keys: bson.D{{Key: "posts", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tagFollowsCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "tag", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = mutesCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "kind", Value: 1}, {Key: "value", Value: 1}}},
+		{
+			// Expired mutes are filtered out on read, the TTL index just clears them away
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+// legacyFollowLists holds the follow arrays users embedded before the follows collection
+type legacyFollowLists struct {
+	ID        primitive.ObjectID   `bson:"_id"`
+	Following []primitive.ObjectID `bson:"following"`
+	Followers []primitive.ObjectID `bson:"followers"`
+}
+
+// migrateFollowGraph turns the embedded followers/following arrays into follow edges.
+// An edge listed on either side is kept, then counts are recomputed and the arrays dropped.
+func migrateFollowGraph(ctx context.Context) error {
+	cursor, err := usersCollection.Find(
+		ctx,
+		bson.M{"$or": []bson.M{
+			{"following": bson.M{"$exists": true}},
+			{"followers": bson.M{"$exists": true}},
+		}},
+		options.Find().SetProjection(bson.M{"following": 1, "followers": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var lists legacyFollowLists
+		if err := cursor.Decode(&lists); err != nil {
+			return err
+		}
+		for _, followeeID := range lists.Following {
+			if err := upsertFollowEdge(ctx, lists.ID, followeeID); err != nil {
+				return err
+			}
+		}
+		for _, followerID := range lists.Followers {
+			if err := upsertFollowEdge(ctx, followerID, lists.ID); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	if err := recountFollows(ctx); err != nil {
+		return err
+	}
+
+	_, err = usersCollection.UpdateMany(
+		ctx,
+		bson.M{},
+		bson.M{"$unset": bson.M{"following": "", "followers": ""}},
+	)
+	return err
+}
+
+// upsertFollowEdge creates the edge unless it already exists
+func upsertFollowEdge(ctx context.Context, followerID, followeeID primitive.ObjectID) error {
+	if followerID == followeeID {
+		return nil
+	}
+	_, err := followsCollection.UpdateOne(
+		ctx,
+		bson.M{"follower_id": followerID, "followee_id": followeeID},
+		bson.M{"$setOnInsert": bson.M{"created_at": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// recountFollows sets every user's follower and following counts from the follows collection
+func recountFollows(ctx context.Context) error {
+	cursor, err := usersCollection.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+
+		followers, err := followsCollection.CountDocuments(ctx, bson.M{"followee_id": user.ID})
+		if err != nil {
+			return err
+		}
+		following, err := followsCollection.CountDocuments(ctx, bson.M{"follower_id": user.ID})
+		if err != nil {
+			return err
+		}
+
+		_, err = usersCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": user.ID},
+			bson.M{"$set": bson.M{"follower_count": followers, "following_count": following}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// migrateTagCase lowercases the tags stored before tags were normalized on write
+func migrateTagCase(ctx context.Context) error {
+	normalized := bson.M{"$map": bson.M{
+		"input": "$tags",
+		"in": bson.M{"$toLower": bson.M{"$ltrim": bson.M{
+			"input": bson.M{"$trim": bson.M{"input": "$$this"}},
+			"chars": "#",
+		}}},
+	}}
+	_, err := postsCollection.UpdateMany(
+		ctx,
+		bson.M{"tags.0": bson.M{"$exists": true}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"tags": bson.M{"$setUnion": bson.A{normalized}}}}}},
+	)
+	if err != nil {
+		return err
+	}
+	return migrateEmptyTags(ctx)
+}
+
+// migrateEmptyTags drops the tags that were nothing but whitespace or # before normalizing
+func migrateEmptyTags(ctx context.Context) error {
+	_, err := postsCollection.UpdateMany(ctx, bson.M{"tags": ""}, bson.M{"$pull": bson.M{"tags": ""}})
+	return err
+}
+
+// migrateNotificationGroups gives notifications stored before grouping the fields groups use.
+// They are left ungrouped, only new notifications open groups.
+func migrateNotificationGroups(ctx context.Context) error {
+	_, err := notificationsCollection.UpdateMany(ctx, bson.M{"actor_ids": bson.M{"$exists": false}}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"actor_ids":   bson.A{"$actor_id"},
+			"actor_count": 1,
+			"updated_at":  "$created_at",
+		}}},
+	})
+	return err
+}
+
+// migrateDuplicateReposts removes all but the oldest live plain repost of each original by the
+// same user, which the check before the unique index let through, and fixes the repost counts.
+// They are deleted outright, soft deleting them together would collide on the index.
+func migrateDuplicateReposts(ctx context.Context) error {
+	cursor, err := postsCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: notDeleted(bson.M{"repost_of": bson.M{"$exists": true}, "content": ""})}},
+		{{Key: "$sort", Value: bson.M{"created_at": 1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"user_id": "$user_id", "repost_of": "$repost_of"},
+			"posts": bson.M{"$push": "$_id"},
+		}}},
+		{{Key: "$match", Value: bson.M{"posts.1": bson.M{"$exists": true}}}},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var group struct {
+			ID struct {
+				RepostOf primitive.ObjectID `bson:"repost_of"`
+			} `bson:"_id"`
+			Posts []primitive.ObjectID `bson:"posts"`
+		}
+		if err := cursor.Decode(&group); err != nil {
+			return err
+		}
+		duplicates := group.Posts[1:]
+		_, err = feedsCollection.UpdateMany(
+			ctx,
+			bson.M{"posts": bson.M{"$in": duplicates}},
+			bson.M{"$pull": bson.M{"posts": bson.M{"$in": duplicates}}},
+		)
+		if err != nil {
+			return err
+		}
+		if _, err = postsCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": duplicates}}); err != nil {
+			return err
+		}
+		_, err = postsCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": group.ID.RepostOf},
+			bson.M{"$inc": bson.M{"repost_count": -len(duplicates)}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// migrateRepostAuthors fills in repost_of_user_id on reposts stored before it existed, which
+// block and privacy filters match on
+func migrateRepostAuthors(ctx context.Context) error {
+	cursor, err := postsCollection.Find(
+		ctx,
+		bson.M{"repost_of": bson.M{"$exists": true}, "repost_of_user_id": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"repost_of": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var repost models.Post
+		if err := cursor.Decode(&repost); err != nil {
+			return err
+		}
+		var original models.Post
+		err := postsCollection.FindOne(
+			ctx,
+			bson.M{"_id": *repost.RepostOf},
+			options.FindOne().SetProjection(bson.M{"user_id": 1}),
+		).Decode(&original)
+		if err == mongo.ErrNoDocuments {
+			// The original was purged, there is no author left to record
+			continue
+		} else if err != nil {
+			return err
+		}
+		_, err = postsCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": repost.ID},
+			bson.M{"$set": bson.M{"repost_of_user_id": original.UserID}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// migrateNotificationGroupActors seeds the distinct actors of open groups with their recent ones.
+// Older actors that already dropped out of those can't be recovered and may be counted again.
+func migrateNotificationGroupActors(ctx context.Context) error {
+	_, err := notificationsCollection.UpdateMany(
+		ctx,
+		bson.M{"group_key": bson.M{"$type": "string"}, "group_actor_ids": bson.M{"$exists": false}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"group_actor_ids": "$actor_ids"}}}},
+	)
+	return err
+}
@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"feed/initializers"
+	"feed/models"
+	"feed/visibility"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var mutesCollection *mongo.Collection = initializers.OpenCollection(initializers.Client, "mutes")
+
+// maxMuteValueLength bounds keywords and patterns so matching stays cheap
+const maxMuteValueLength = 200
+
+// CreateMute hides an author, tag, keyword or pattern from the user's feed, optionally until expires_at
+func CreateMute(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var mute models.Mute
+	if err := c.ShouldBindJSON(&mute); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	mute.Value = strings.TrimSpace(mute.Value)
+	if len(mute.Value) > maxMuteValueLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Mute value is longer than %d characters", maxMuteValueLength)})
+		return
+	}
+	if err := visibility.ValidateMute(mute); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if mute.ExpiresAt != nil && mute.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at is in the past"})
+		return
+	}
+	if mute.Kind == models.MuteUser {
+		mutedID, _ := primitive.ObjectIDFromHex(mute.Value)
+		count, err := usersCollection.CountDocuments(c.Request.Context(), notDeleted(bson.M{"_id": mutedID}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+			return
+		}
+		if count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+	}
+
+	mute.ID = primitive.NilObjectID
+	mute.UserID = userID
+	mute.CreatedAt = time.Now()
+	result, err := mutesCollection.InsertOne(c.Request.Context(), mute)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create mute"})
+		return
+	}
+	mute.ID = result.InsertedID.(primitive.ObjectID)
+
+	if err = invalidateFeedCache(c.Request.Context(), userID); err != nil {
+		fmt.Println("Error invalidating feed cache:", err)
+	}
+	c.JSON(http.StatusCreated, mute)
+}
+
+// ListMutes lists the user's active mutes
+func ListMutes(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	mutes, err := activeMutes(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve mutes"})
+		return
+	}
+	c.JSON(http.StatusOK, mutes)
+}
+
+// DeleteMute removes one of the user's mutes
+func DeleteMute(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	muteID, err := primitive.ObjectIDFromHex(c.Param("muteID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mute ID"})
+		return
+	}
+
+	result, err := mutesCollection.DeleteOne(c.Request.Context(), bson.M{"_id": muteID, "user_id": userID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete mute"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mute not found"})
+		return
+	}
+
+	if err = invalidateFeedCache(c.Request.Context(), userID); err != nil {
+		fmt.Println("Error invalidating feed cache:", err)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Mute deleted successfully"})
+}
+
+// activeMutes returns the user's mutes that have not expired. Expired ones are also removed
+// by a TTL index, but that only runs once a minute.
+func activeMutes(ctx context.Context, userID primitive.ObjectID) ([]models.Mute, error) {
+	mutes := []models.Mute{}
+	cursor, err := mutesCollection.Find(
+		ctx,
+		bson.M{
+			"user_id": userID,
+			"$or": []bson.M{
+				{"expires_at": bson.M{"$exists": false}},
+				{"expires_at": bson.M{"$gt": time.Now()}},
+			},
+		},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &mutes); err != nil {
+		return nil, err
+	}
+	return mutes, nil
+}
+
+// loadMutes adds the viewer's active mutes to its visibility context
+func loadMutes(ctx context.Context, viewer *visibility.Viewer) error {
+	mutes, err := activeMutes(ctx, viewer.ID)
+	if err != nil {
+		return err
+	}
+	viewer.Mutes, err = visibility.NewMutes(mutes)
+	return err
+}
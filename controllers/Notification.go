@@ -0,0 +1,327 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"feed/initializers"
+	"feed/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var notificationsCollection *mongo.Collection = initializers.OpenCollection(initializers.Client, "notifications")
+
+// maxNotificationActors is how many of a group's most recent actors are kept and shown
+const maxNotificationActors = 5
+
+// maxGroupedActors bounds the distinct actors an open group remembers to count each only once.
+// Past it, actors who are not remembered are counted as new.
+const maxGroupedActors = 1000
+
+// unreadCountTTL bounds how stale a cached unread count can get if an invalidation is lost
+const unreadCountTTL = 10 * time.Minute
+
+// NotificationView is a notification with its recent actors and a line describing it
+type NotificationView struct {
+	models.Notification
+	Actors  []models.UserSummary `json:"actors"`
+	Message string               `json:"message"`
+}
+
+// ListNotifications pages through a user's notifications, most recently active first, ?unread=true for unread only
+func ListNotifications(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	limit, err := parseLimit(c, 20, 100)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit number"})
+		return
+	}
+	filter, err := cursorFilter(c.Query("cursor"), "updated_at")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+	filter["user_id"] = userID
+	if unread, _ := strconv.ParseBool(c.Query("unread")); unread {
+		filter["read_at"] = bson.M{"$exists": false}
+	}
+	ctx := c.Request.Context()
+
+	notifications := []models.Notification{}
+	cursor, err := notificationsCollection.Find(
+		ctx,
+		filter,
+		options.Find().
+			SetSort(bson.D{{Key: "updated_at", Value: -1}, {Key: "_id", Value: -1}}).
+			SetLimit(int64(limit+1)),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve notifications"})
+		return
+	}
+	if err = cursor.All(ctx, &notifications); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode notifications"})
+		return
+	}
+
+	nextCursor := ""
+	if len(notifications) > limit {
+		notifications = notifications[:limit]
+		last := notifications[len(notifications)-1]
+		nextCursor = encodeCursor(last.UpdatedAt, last.ID)
+	}
+
+	views, err := notificationViews(ctx, notifications)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve actors"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"notifications": views,
+		"next_cursor":   nextCursor,
+	})
+}
+
+// MarkNotificationsRead marks the listed notifications read, or all of them when no IDs are given
+func MarkNotificationsRead(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	var body struct {
+		IDs []primitive.ObjectID `json:"ids"`
+	}
+	// The body is optional, an empty one marks everything read
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	filter := bson.M{"user_id": userID, "read_at": bson.M{"$exists": false}}
+	if len(body.IDs) > 0 {
+		filter["_id"] = bson.M{"$in": body.IDs}
+	}
+	// Dropping the group key closes the group, later events start a new notification
+	result, err := notificationsCollection.UpdateMany(c.Request.Context(), filter, bson.M{
+		"$set":   bson.M{"read_at": time.Now()},
+		"$unset": bson.M{"group_key": "", "group_actor_ids": ""},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark notifications read"})
+		return
+	}
+
+	invalidateUnreadCount(c.Request.Context(), userID)
+	c.JSON(http.StatusOK, gin.H{"marked": result.ModifiedCount})
+}
+
+// GetUnreadNotificationCount serves the number of unread notifications, cached in Redis
+func GetUnreadNotificationCount(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	ctx := c.Request.Context()
+	key := unreadCountKey(userID)
+
+	count, err := deps.Redis.Get(ctx, key).Int64()
+	if err == nil {
+		c.JSON(http.StatusOK, gin.H{"unread": count})
+		return
+	} else if err != redis.Nil {
+		fmt.Println("Error reading unread count:", err)
+	}
+
+	count, err = notificationsCollection.CountDocuments(ctx, bson.M{
+		"user_id": userID,
+		"read_at": bson.M{"$exists": false},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count notifications"})
+		return
+	}
+	if err = deps.Redis.Set(ctx, key, count, unreadCountTTL).Err(); err != nil {
+		fmt.Println("Error caching unread count:", err)
+	}
+	c.JSON(http.StatusOK, gin.H{"unread": count})
+}
+
+// notify stores a notification for its recipient. Grouped types fold into the recipient's unread
+// notification of the same kind, so a burst of likes on a post becomes one notification.
+func notify(ctx context.Context, notification models.Notification) error {
+	if notification.UserID == notification.ActorID {
+		return nil
+	}
+	now := time.Now()
+
+	notification.GroupKey = notificationGroupKey(notification)
+	if notification.GroupKey == "" {
+		notification.ActorIDs = []primitive.ObjectID{notification.ActorID}
+		notification.ActorCount = 1
+		notification.CreatedAt = now
+		notification.UpdatedAt = now
+		if _, err := notificationsCollection.InsertOne(ctx, notification); err != nil {
+			return err
+		}
+		invalidateUnreadCount(ctx, notification.UserID)
+		return nil
+	}
+
+	err := groupNotification(ctx, notification, now)
+	if mongo.IsDuplicateKeyError(err) {
+		// Another event opened the group at the same moment, join it
+		err = groupNotification(ctx, notification, now)
+	}
+	if err != nil {
+		return err
+	}
+	invalidateUnreadCount(ctx, notification.UserID)
+	return nil
+}
+
+// groupNotification adds the actor to the open group, creating it if there is none. The group
+// remembers every distinct actor, so one who comes back is moved to the front of the recent
+// ones without being counted again, even after dropping out of them.
+func groupNotification(ctx context.Context, notification models.Notification, now time.Time) error {
+	actor := notification.ActorID
+	previous := bson.M{"$ifNull": bson.A{"$actor_ids", bson.A{}}}
+	grouped := bson.M{"$ifNull": bson.A{"$group_actor_ids", bson.A{}}}
+	known := bson.M{"$in": bson.A{actor, grouped}}
+	full := bson.M{"$gte": bson.A{bson.M{"$size": grouped}, maxGroupedActors}}
+	set := bson.M{
+		"type":       notification.Type,
+		"actor_id":   actor,
+		"updated_at": now,
+		"created_at": bson.M{"$ifNull": bson.A{"$created_at", now}},
+		"actor_count": bson.M{"$add": bson.A{
+			bson.M{"$ifNull": bson.A{"$actor_count", 0}},
+			bson.M{"$cond": bson.A{known, 0, 1}},
+		}},
+		"group_actor_ids": bson.M{"$cond": bson.A{
+			bson.M{"$or": bson.A{known, full}},
+			grouped,
+			bson.M{"$concatArrays": bson.A{grouped, bson.A{actor}}},
+		}},
+		"actor_ids": bson.M{"$slice": bson.A{
+			bson.M{"$concatArrays": bson.A{
+				bson.A{actor},
+				bson.M{"$filter": bson.M{"input": previous, "cond": bson.M{"$ne": bson.A{"$$this", actor}}}},
+			}},
+			maxNotificationActors,
+		}},
+	}
+	if notification.PostID != nil {
+		set["post_id"] = *notification.PostID
+	}
+
+	_, err := notificationsCollection.UpdateOne(
+		ctx,
+		bson.M{"user_id": notification.UserID, "group_key": notification.GroupKey},
+		mongo.Pipeline{{{Key: "$set", Value: set}}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// notificationGroupKey returns the key similar notifications share, empty for types that stand alone
+func notificationGroupKey(notification models.Notification) string {
+	switch notification.Type {
+	case models.NotificationLike:
+		return notification.Type + ":" + notification.PostID.Hex()
+	case models.NotificationFollow, models.NotificationFollowRequest:
+		return notification.Type
+	}
+	return ""
+}
+
+// notificationViews attaches the recent actors and a message to each notification
+func notificationViews(ctx context.Context, notifications []models.Notification) ([]NotificationView, error) {
+	seen := make(map[primitive.ObjectID]bool)
+	ids := make([]primitive.ObjectID, 0)
+	for _, notification := range notifications {
+		for _, id := range notification.ActorIDs {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	summaries, err := userSummaries(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[primitive.ObjectID]models.UserSummary, len(summaries))
+	for _, summary := range summaries {
+		byID[summary.ID] = summary
+	}
+
+	views := make([]NotificationView, len(notifications))
+	for i, notification := range notifications {
+		actors := make([]models.UserSummary, 0, len(notification.ActorIDs))
+		for _, id := range notification.ActorIDs {
+			if summary, ok := byID[id]; ok {
+				actors = append(actors, summary)
+			}
+		}
+		views[i] = NotificationView{
+			Notification: notification,
+			Actors:       actors,
+			Message:      notificationMessage(notification, actors),
+		}
+	}
+	return views, nil
+}
+
+// notificationMessage describes a notification, like "alice and 12 others liked your post"
+func notificationMessage(notification models.Notification, actors []models.UserSummary) string {
+	subject := "Someone"
+	if len(actors) > 0 {
+		subject = actors[0].Username
+	}
+	switch others := notification.ActorCount - 1; {
+	case others == 1:
+		subject += " and 1 other"
+	case others > 1:
+		subject += fmt.Sprintf(" and %d others", others)
+	}
+
+	switch notification.Type {
+	case models.NotificationLike:
+		return subject + " liked your post"
+	case models.NotificationFollow:
+		return subject + " followed you"
+	case models.NotificationFollowRequest:
+		return subject + " asked to follow you"
+	case models.NotificationMention:
+		return subject + " mentioned you in a post"
+	}
+	return subject + " interacted with you"
+}
+
+// invalidateUnreadCount drops the cached unread count, the next read recounts it
+func invalidateUnreadCount(ctx context.Context, userID primitive.ObjectID) {
+	if err := deps.Redis.Del(ctx, unreadCountKey(userID)).Err(); err != nil {
+		fmt.Println("Error invalidating unread count:", err)
+	}
+}
+
+func unreadCountKey(userID primitive.ObjectID) string {
+	return fmt.Sprintf("notifications:unread:%s", userID.Hex())
+}
@@ -0,0 +1,263 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"feed/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// feedRebuildPostLimit is how many of the latest followed posts a rebuilt feed holds
+const feedRebuildPostLimit = 1000
+
+// RebuildFeed recomputes the user's fanned out feed from the people they follow and drops the
+// cached pages. Celebrities' and followed tags' posts are pulled in on read so they are not stored.
+// It returns how many posts the feed holds.
+func RebuildFeed(ctx context.Context, userID primitive.ObjectID) (int, error) {
+	if err := usersCollection.FindOne(ctx, notDeleted(bson.M{"_id": userID})).Err(); err != nil {
+		return 0, err
+	}
+
+	viewer, err := loadViewer(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	authorIDs, _, err := splitByCelebrity(ctx, viewer.FollowingIDs())
+	if err != nil {
+		return 0, err
+	}
+
+	cursor, err := postsCollection.Find(
+		ctx,
+		notDeleted(visibleTo(viewer, bson.M{"user_id": bson.M{"$in": authorIDs}})),
+		options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: -1}}).
+			SetLimit(feedRebuildPostLimit).
+			SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return 0, err
+	}
+	var posts []models.Post
+	if err = cursor.All(ctx, &posts); err != nil {
+		return 0, err
+	}
+	postIDs := make([]primitive.ObjectID, 0, len(posts))
+	for _, post := range posts {
+		postIDs = append(postIDs, post.ID)
+	}
+
+	now := time.Now()
+	_, err = feedsCollection.UpdateOne(
+		ctx,
+		bson.M{"user_id": userID},
+		bson.M{"$set": bson.M{"posts": postIDs, "updated_at": now}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return 0, err
+	}
+	_, err = usersCollection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"last_feed_update": now}})
+	if err != nil {
+		return 0, err
+	}
+	return len(postIDs), invalidateFeedCache(ctx, userID)
+}
+
+// RebuildAllFeeds rebuilds the feed of every user and returns how many were rebuilt. A failing
+// user is reported and skipped so one bad account doesn't stop the run.
+func RebuildAllFeeds(ctx context.Context) (int, error) {
+	cursor, err := usersCollection.Find(ctx, notDeleted(bson.M{}), options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	rebuilt := 0
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return rebuilt, err
+		}
+		if _, err := RebuildFeed(ctx, user.ID); err != nil {
+			fmt.Println("Error rebuilding feed of", user.ID.Hex()+":", err)
+			continue
+		}
+		rebuilt++
+	}
+	return rebuilt, cursor.Err()
+}
+
+// FlushFeedCache drops every cached page of the user's feed
+func FlushFeedCache(ctx context.Context, userID primitive.ObjectID) error {
+	return invalidateFeedCache(ctx, userID)
+}
+
+// SetCelebrity sets the user's fan-out mode and queues the feed migration when it changes.
+// Unpinned, the automatic policy is applied right away and may override isCelebrity.
+// It returns mongo.ErrNoDocuments when the user doesn't exist.
+func SetCelebrity(ctx context.Context, userID primitive.ObjectID, isCelebrity, pinned bool) error {
+	var before models.User
+	err := usersCollection.FindOneAndUpdate(
+		ctx,
+		notDeleted(bson.M{"_id": userID}),
+		bson.M{"$set": bson.M{"is_celebrity": isCelebrity, "celebrity_pinned": pinned}},
+	).Decode(&before)
+	if err != nil {
+		return err
+	}
+
+	if before.IsCelebrity != isCelebrity {
+		if err = queueFeedMigration(ctx, userID, isCelebrity); err != nil {
+			return err
+		}
+	}
+	if !pinned {
+		return applyCelebrityPolicy(ctx, bson.M{"_id": userID})
+	}
+	return nil
+}
+
+// FeedStats describes the state of one user's feed
+type FeedStats struct {
+	UserID              primitive.ObjectID `json:"user_id"`
+	Username            string             `json:"username"`
+	IsCelebrity         bool               `json:"is_celebrity"`
+	FeedMigration       string             `json:"feed_migration,omitempty"`
+	Following           int                `json:"following"`
+	CelebritiesFollowed int                `json:"celebrities_followed"`
+	TagsFollowed        int                `json:"tags_followed"`
+	FeedPosts           int                `json:"feed_posts"`
+	CachedPages         int                `json:"cached_pages"`
+	FeedUpdatedAt       time.Time          `json:"feed_updated_at"`
+	LastFeedUpdate      time.Time          `json:"last_feed_update"`
+}
+
+// GetFeedStats gathers the feed stats of a user
+func GetFeedStats(ctx context.Context, userID primitive.ObjectID) (*FeedStats, error) {
+	var user models.User
+	if err := usersCollection.FindOne(ctx, notDeleted(bson.M{"_id": userID})).Decode(&user); err != nil {
+		return nil, err
+	}
+	stats := &FeedStats{
+		UserID:         user.ID,
+		Username:       user.Username,
+		IsCelebrity:    user.IsCelebrity,
+		FeedMigration:  user.FeedMigration,
+		LastFeedUpdate: user.LastFeedUpdate,
+	}
+
+	following, err := followingIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	_, celebrities, err := splitByCelebrity(ctx, following)
+	if err != nil {
+		return nil, err
+	}
+	stats.Following = len(following)
+	stats.CelebritiesFollowed = len(celebrities)
+
+	tags, err := tagFollowsCollection.CountDocuments(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+	stats.TagsFollowed = int(tags)
+
+	var feed models.Feed
+	err = feedsCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&feed)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	stats.FeedPosts = len(feed.Posts)
+	stats.FeedUpdatedAt = feed.UpdatedAt
+
+	iter := deps.Redis.Scan(ctx, 0, fmt.Sprintf("feed:%s:*", userID.Hex()), 100).Iterator()
+	for iter.Next(ctx) {
+		stats.CachedPages++
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// UserExport is everything stored about a user
+type UserExport struct {
+	User               models.User               `json:"user"`
+	UsernameRedirects  []models.UsernameRedirect `json:"username_redirects"`
+	Following          []primitive.ObjectID      `json:"following"`
+	Followers          []primitive.ObjectID      `json:"followers"`
+	FollowRequests     []models.FollowRequest    `json:"follow_requests"`
+	Blocks             []models.Block            `json:"blocks"`
+	Mutes              []models.Mute             `json:"mutes"`
+	TagFollows         []models.TagFollow        `json:"tag_follows"`
+	Posts              []models.Post             `json:"posts"`
+	Revisions          []models.PostRevision     `json:"revisions"`
+	Likes              []models.Like             `json:"likes"`
+	Notifications      []models.Notification     `json:"notifications"`
+	ActorNotifications []models.Notification     `json:"actor_notifications"`
+	Feed               *models.Feed              `json:"feed,omitempty"`
+}
+
+// ExportUser collects the user's document and everything that belongs to them or names them:
+// their old handles, the revisions they made and the notifications they acted in
+func ExportUser(ctx context.Context, userID primitive.ObjectID) (*UserExport, error) {
+	export := &UserExport{}
+	if err := usersCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&export.User); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if export.Following, err = followingIDs(ctx, userID); err != nil {
+		return nil, err
+	}
+	if export.Followers, err = followerIDs(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	byUser := bson.M{"user_id": userID}
+	lists := []struct {
+		collection *mongo.Collection
+		filter     bson.M
+		into       interface{}
+	}{
+		{usernameRedirectsCollection, byUser, &export.UsernameRedirects},
+		{followRequestsCollection, bson.M{"$or": []bson.M{{"requester_id": userID}, {"target_id": userID}}}, &export.FollowRequests},
+		{blocksCollection, bson.M{"$or": []bson.M{{"blocker_id": userID}, {"blocked_id": userID}}}, &export.Blocks},
+		{mutesCollection, byUser, &export.Mutes},
+		{tagFollowsCollection, byUser, &export.TagFollows},
+		{postsCollection, byUser, &export.Posts},
+		{postRevisionsCollection, bson.M{"editor_id": userID}, &export.Revisions},
+		{likesCollection, byUser, &export.Likes},
+		{notificationsCollection, byUser, &export.Notifications},
+		{notificationsCollection, bson.M{
+			"user_id": bson.M{"$ne": userID},
+			"$or":     []bson.M{{"actor_id": userID}, {"actor_ids": userID}, {"group_actor_ids": userID}},
+		}, &export.ActorNotifications},
+	}
+	for _, list := range lists {
+		cursor, err := list.collection.Find(ctx, list.filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+		if err != nil {
+			return nil, err
+		}
+		if err = cursor.All(ctx, list.into); err != nil {
+			return nil, err
+		}
+	}
+
+	var feed models.Feed
+	err = feedsCollection.FindOne(ctx, byUser).Decode(&feed)
+	if err == nil {
+		export.Feed = &feed
+	} else if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	return export, nil
+}
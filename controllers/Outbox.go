@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"feed/initializers"
+	"feed/models"
+
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var outboxCollection *mongo.Collection = initializers.OpenCollection(initializers.Client, "outbox")
+
+// eventStream is the Redis stream the relay publishes events to
+const eventStream = "events"
+
+// eventStreamMaxLen roughly bounds the stream, consumers are expected to keep up well within it
+const eventStreamMaxLen = 100000
+
+// outboxLease is how long a relay owns an entry before another may publish it
+const outboxLease = 30 * time.Second
+
+// outboxRetention is how long sent entries are kept before they expire
+const outboxRetention = 24 * time.Hour
+
+// eventEnvelope is the body of every published event. ID is the outbox entry's and stays the
+// same when an event is published twice, consumers use it as their idempotency key.
+type eventEnvelope struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// recordEvent writes an event to the outbox. Call it with the session context of the transaction
+// making the change, so the event is stored if and only if the change is.
+func recordEvent(ctx context.Context, event string, data any) error {
+	now := time.Now()
+	id := primitive.NewObjectID()
+	payload, err := json.Marshal(eventEnvelope{ID: id.Hex(), Event: event, CreatedAt: now, Data: data})
+	if err != nil {
+		return err
+	}
+	_, err = outboxCollection.InsertOne(ctx, models.OutboxEntry{
+		ID:        id,
+		Event:     event,
+		Payload:   string(payload),
+		CreatedAt: now,
+	})
+	return err
+}
+
+// StartOutboxRelay publishes outbox entries to the event stream until ctx is cancelled. An entry is
+// marked sent after it is published, so a crash in between publishes it again: delivery is at
+// least once.
+func StartOutboxRelay(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		heartbeat("outbox_relay", interval)
+		for {
+			entry, err := claimOutboxEntry(ctx)
+			if err != nil {
+				if err != mongo.ErrNoDocuments {
+					fmt.Println("Error claiming outbox entry:", err)
+				}
+				break
+			}
+			if err = relayOutboxEntry(ctx, entry); err != nil {
+				// Left unsent, it is picked up again once the lease runs out
+				fmt.Println("Error relaying outbox entry", entry.ID.Hex()+":", err)
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// claimOutboxEntry takes the lease on the oldest unsent entry nobody else is publishing
+func claimOutboxEntry(ctx context.Context) (*models.OutboxEntry, error) {
+	now := time.Now()
+	var entry models.OutboxEntry
+	err := outboxCollection.FindOneAndUpdate(
+		ctx,
+		bson.M{"sent_at": bson.M{"$exists": false}, "lease_until": bson.M{"$lt": now}},
+		bson.M{
+			"$set": bson.M{"lease_until": now.Add(outboxLease)},
+			"$inc": bson.M{"attempts": 1},
+		},
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "created_at", Value: 1}}).
+			SetReturnDocument(options.After),
+	).Decode(&entry)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// relayOutboxEntry publishes the entry to the event stream and marks it sent
+func relayOutboxEntry(ctx context.Context, entry *models.OutboxEntry) error {
+	err := deps.Redis.XAdd(ctx, &redis.XAddArgs{
+		Stream: eventStream,
+		MaxLen: eventStreamMaxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"id":      entry.ID.Hex(),
+			"event":   entry.Event,
+			"payload": entry.Payload,
+		},
+	}).Err()
+	if err != nil {
+		return err
+	}
+
+	_, err = outboxCollection.UpdateOne(ctx, bson.M{"_id": entry.ID}, bson.M{"$set": bson.M{"sent_at": time.Now()}})
+	return err
+}
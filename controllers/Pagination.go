@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// parseLimit reads the limit query parameter, clamped to max
+func parseLimit(c *gin.Context, fallback, max int) (int, error) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(fallback)))
+	if err != nil || limit < 1 {
+		return 0, errors.New("invalid limit number")
+	}
+	if limit > max {
+		limit = max
+	}
+	return limit, nil
+}
+
+// encodeCursor builds an opaque cursor pointing just past a document sorted by (time, _id) descending
+func encodeCursor(at time.Time, id primitive.ObjectID) string {
+	raw := strconv.FormatInt(at.UnixNano(), 10) + "_" + id.Hex()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// cursorFilter decodes a cursor into a filter matching the documents after it.
+// An empty cursor matches everything.
+func cursorFilter(cursor, timeField string) (bson.M, error) {
+	if cursor == "" {
+		return bson.M{}, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), "_", 2)
+	if len(parts) != 2 {
+		return nil, errInvalidCursor
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	id, err := primitive.ObjectIDFromHex(parts[1])
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	at := time.Unix(0, nanos)
+	return bson.M{"$or": []bson.M{
+		{timeField: bson.M{"$lt": at}},
+		{timeField: at, "_id": bson.M{"$lt": id}},
+	}}, nil
+}
@@ -2,13 +2,18 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
+	"feed/entities"
 	"feed/initializers"
 	"feed/models"
+	"feed/visibility"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -17,6 +22,20 @@ import (
 
 var postsCollection *mongo.Collection = initializers.OpenCollection(initializers.Client, "post")
 
+// likesCollection holds who liked what, one document per user and post
+var likesCollection *mongo.Collection = initializers.OpenCollection(initializers.Client, "likes")
+
+var (
+	errAlreadyLiked = errors.New("post already liked")
+	errNotLiked     = errors.New("post not liked")
+)
+
+// notDeleted restricts a filter to documents that have not been soft deleted
+func notDeleted(filter bson.M) bson.M {
+	filter["deleted_at"] = bson.M{"$exists": false}
+	return filter
+}
+
 // CreatePost handles the creation of a new post
 func CreatePost(c *gin.Context) {
 	id, _ := primitive.ObjectIDFromHex(c.Param("id"))
@@ -26,69 +45,266 @@ func CreatePost(c *gin.Context) {
 		return
 	}
 
+	if post.Visibility == "" {
+		post.Visibility = models.VisibilityPublic
+	}
+	if !visibility.ValidVisibility(post.Visibility) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid visibility"})
+		return
+	}
+
 	post.CreatedAt = time.Now()
 	post.LikeCount = 0
 	post.UserID = id
-	result, err := postsCollection.InsertOne(context.Background(), post)
+
+	// Posts from private accounts only reach approved followers
+	audience, err := postAudience(context.Background(), id, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve author"})
+		return
+	}
+	post.PrivateTo = audience
+
+	// Mentions and hashtags come from the content
+	if err = applyEntities(context.Background(), &post); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve mentions"})
+		return
+	}
+
+	// The post and its event are written together, so the event can't be lost to a crash
+	post.ID = primitive.NewObjectID()
+	err = withTransaction(context.Background(), func(sc mongo.SessionContext) error {
+		if _, err := postsCollection.InsertOne(sc, post); err != nil {
+			return err
+		}
+		return recordEvent(sc, WebhookPostCreated, gin.H{"post": post})
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create post"})
 		return
 	}
 
-	post.ID = result.InsertedID.(primitive.ObjectID)
+	notifyMentions(context.Background(), &post, nil)
+	recordTagUse(context.Background(), &post, post.Tags)
 	c.JSON(http.StatusCreated, post)
 }
 
 // GetPost retrieves a post by ID
 func GetPost(c *gin.Context) {
 	id, _ := primitive.ObjectIDFromHex(c.Param("id"))
-	var post models.Post
-	err := postsCollection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&post)
+	viewer, err := requestViewer(c)
 	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load viewer"})
+		return
+	}
+
+	var post models.Post
+	err = postsCollection.FindOne(context.Background(), notDeleted(bson.M{"_id": id})).Decode(&post)
+	if err != nil || !viewer.CanSee(&post) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
 		return
 	}
 	c.JSON(http.StatusOK, post)
 }
 
-// UpdatePost updates a post's information
+// editablePostFields are the only fields UpdatePost accepts. Visibility stays as it was, since
+// reposts and feeds were built for the original audience.
+var editablePostFields = map[string]bool{"content": true, "tags": true}
+
+// UpdatePost edits a post's content and tags, keeping the previous version as a revision
 func UpdatePost(c *gin.Context) {
 	id, _ := primitive.ObjectIDFromHex(c.Param("id"))
 	var updateData bson.M
-	if err := c.ShouldBindJSON(&updateData); err != nil {
+	if err := c.ShouldBindBodyWith(&updateData, binding.JSON); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for field := range updateData {
+		if !editablePostFields[field] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Field " + field + " cannot be edited"})
+			return
+		}
+	}
+	var fields struct {
+		Content string   `json:"content"`
+		Tags    []string `json:"tags"`
+	}
+	if err := c.ShouldBindBodyWith(&fields, binding.JSON); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	_, err := postsCollection.UpdateOne(
-		context.Background(),
-		bson.M{"_id": id},
-		bson.M{"$set": updateData},
-	)
+	var post models.Post
+	err := postsCollection.FindOne(context.Background(), notDeleted(bson.M{"_id": id})).Decode(&post)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update post"})
+		c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
 		return
 	}
 
+	content, tags := post.Content, post.Tags
+	if _, ok := updateData["content"]; ok {
+		content = fields.Content
+	}
+	if _, ok := updateData["tags"]; ok {
+		tags = fields.Tags
+	}
+
+	if !editPostResponse(c, &post, content, tags) {
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Post updated successfully"})
 }
 
-// DeletePost deletes a post
+// editPostResponse applies an edit as the requesting user, who must be the author, writing the
+// error response when it fails
+func editPostResponse(c *gin.Context, post *models.Post, content string, tags []string) bool {
+	editorID, ok := viewerID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "X-User-ID header is required"})
+		return false
+	}
+	if editorID != post.UserID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the author can edit this post"})
+		return false
+	}
+	_, _, err := editPost(c.Request.Context(), post, content, tags, editorID)
+	switch {
+	case err == errEditWindowClosed:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Post can no longer be edited"})
+	case err == mongo.ErrNoDocuments:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update post"})
+	}
+	return err == nil
+}
+
+// DeletePost soft deletes a post. It can be restored until the purge job removes it for good.
 func DeletePost(c *gin.Context) {
 	id, _ := primitive.ObjectIDFromHex(c.Param("id"))
-	_, err := postsCollection.DeleteOne(context.Background(), bson.M{"_id": id})
-	if err != nil {
+	deletedAt := time.Now()
+
+	var post models.Post
+	err := postsCollection.FindOneAndUpdate(
+		context.Background(),
+		notDeleted(bson.M{"_id": id}),
+		bson.M{"$set": bson.M{"deleted_at": deletedAt}},
+	).Decode(&post)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete post"})
 		return
 	}
+
+	// Plain reposts go with the original, stamped with the same time so a restore brings them back
+	repostIDs, err := plainRepostIDs(context.Background(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete reposts"})
+		return
+	}
+	_, err = postsCollection.UpdateMany(
+		context.Background(),
+		notDeleted(bson.M{"_id": bson.M{"$in": repostIDs}}),
+		bson.M{"$set": bson.M{"deleted_at": deletedAt}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete reposts"})
+		return
+	}
+
+	if err = updateRepostCount(context.Background(), &post, -1); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update repost count"})
+		return
+	}
+
+	// The change stream worker drops the cached feeds holding the post and its reposts
 	c.JSON(http.StatusOK, gin.H{"message": "Post deleted successfully"})
 }
 
+// plainRepostIDs returns the IDs of the live plain reposts of a post
+func plainRepostIDs(ctx context.Context, postID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	var reposts []models.Post
+	cursor, err := postsCollection.Find(
+		ctx,
+		notDeleted(bson.M{"repost_of": postID, "content": ""}),
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &reposts); err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, len(reposts))
+	for i, repost := range reposts {
+		ids[i] = repost.ID
+	}
+	return ids, nil
+}
+
+// updateRepostCount moves the repost count of the post a repost shares, if it is one
+func updateRepostCount(ctx context.Context, post *models.Post, delta int) error {
+	if post.RepostOf == nil {
+		return nil
+	}
+	_, err := postsCollection.UpdateOne(ctx, bson.M{"_id": *post.RepostOf}, bson.M{"$inc": bson.M{"repost_count": delta}})
+	return err
+}
+
+// RestorePost brings back a soft deleted post, along with the reposts deleted with it
+func RestorePost(c *gin.Context) {
+	id, _ := primitive.ObjectIDFromHex(c.Param("id"))
+
+	var post models.Post
+	err := postsCollection.FindOne(
+		context.Background(),
+		bson.M{"_id": id, "deleted_at": bson.M{"$exists": true}},
+	).Decode(&post)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deleted post not found"})
+		return
+	}
+
+	if time.Since(*post.DeletedAt) > deps.PostRetention {
+		c.JSON(http.StatusGone, gin.H{"error": "Post can no longer be restored"})
+		return
+	}
+
+	_, err = postsCollection.UpdateMany(
+		context.Background(),
+		bson.M{
+			"$or": []bson.M{
+				{"_id": id},
+				{"repost_of": id, "content": "", "deleted_at": *post.DeletedAt},
+			},
+		},
+		bson.M{"$unset": bson.M{"deleted_at": ""}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore post"})
+		return
+	}
+	if err = updateRepostCount(context.Background(), &post, 1); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update repost count"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Post restored successfully"})
+}
+
 // ListPosts retrieves a list of all posts
 func ListPosts(c *gin.Context) {
+	viewer, err := requestViewer(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load viewer"})
+		return
+	}
+
 	var posts []models.Post
 	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
-	cursor, err := postsCollection.Find(context.Background(), bson.M{}, opts)
+	cursor, err := postsCollection.Find(context.Background(), notDeleted(visibleTo(viewer, bson.M{})), opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve posts"})
 		return
@@ -105,33 +321,93 @@ func ListPosts(c *gin.Context) {
 // LikePost increments the like count of a post
 func LikePost(c *gin.Context) {
 	id, _ := primitive.ObjectIDFromHex(c.Param("id"))
-	_, err := postsCollection.UpdateOne(
-		context.Background(),
-		bson.M{"_id": id},
-		bson.M{"$inc": bson.M{"like_count": 1}},
-	)
-	if err != nil {
+	post, ok := checkInteraction(c, id)
+	if !ok {
+		return
+	}
+
+	actorID, _ := viewerID(c)
+	event := gin.H{"post_id": post.ID, "author_id": post.UserID, "user_id": actorID}
+	err := withTransaction(context.Background(), func(sc mongo.SessionContext) error {
+		_, err := likesCollection.InsertOne(sc, models.Like{PostID: id, UserID: actorID, CreatedAt: time.Now()})
+		if mongo.IsDuplicateKeyError(err) {
+			return errAlreadyLiked
+		} else if err != nil {
+			return err
+		}
+		result, err := postsCollection.UpdateOne(
+			sc,
+			notDeleted(bson.M{"_id": id}),
+			bson.M{"$inc": bson.M{"like_count": 1}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return mongo.ErrNoDocuments
+		}
+		return recordEvent(sc, WebhookPostLiked, event)
+	})
+	if errors.Is(err, errAlreadyLiked) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Post already liked"})
+		return
+	} else if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to like post"})
 		return
 	}
+	recordLike(context.Background(), post, 1)
+
+	err = notify(context.Background(), models.Notification{
+		UserID:  post.UserID,
+		Type:    models.NotificationLike,
+		ActorID: actorID,
+		PostID:  &post.ID,
+	})
+	if err != nil {
+		fmt.Println("Error sending like notification:", err)
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Post liked successfully"})
 }
 
-// UnlikePost decrements the like count of a post
+// UnlikePost removes the user's like of a post
 func UnlikePost(c *gin.Context) {
 	id, _ := primitive.ObjectIDFromHex(c.Param("id"))
-	_, err := postsCollection.UpdateOne(
-		context.Background(),
-		bson.M{"_id": id},
-		bson.M{"$inc": bson.M{"like_count": -1}},
-	)
-	if err != nil {
+	post, ok := checkInteraction(c, id)
+	if !ok {
+		return
+	}
+
+	actorID, _ := viewerID(c)
+	err := withTransaction(context.Background(), func(sc mongo.SessionContext) error {
+		return removeLike(sc, id, actorID)
+	})
+	if errors.Is(err, errNotLiked) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Like not found"})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlike post"})
 		return
 	}
+	recordLike(context.Background(), post, -1)
 	c.JSON(http.StatusOK, gin.H{"message": "Post unliked successfully"})
 }
 
+// removeLike deletes the user's like and takes it off the post's count. Call it inside a transaction.
+func removeLike(sc mongo.SessionContext, postID, userID primitive.ObjectID) error {
+	result, err := likesCollection.DeleteOne(sc, bson.M{"post_id": postID, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errNotLiked
+	}
+	_, err = postsCollection.UpdateOne(sc, bson.M{"_id": postID}, bson.M{"$inc": bson.M{"like_count": -1}})
+	return err
+}
+
 // AddTag adds a tag to a post
 func AddTag(c *gin.Context) {
 	id, _ := primitive.ObjectIDFromHex(c.Param("id"))
@@ -143,19 +419,29 @@ func AddTag(c *gin.Context) {
 		return
 	}
 
-	_, err := postsCollection.UpdateOne(
-		context.Background(),
-		bson.M{"_id": id},
-		bson.M{"$addToSet": bson.M{"tags": tag.Tag}},
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add tag"})
+	normalized := entities.NormalizeTag(tag.Tag)
+	if normalized == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Tag is empty"})
+		return
+	}
+
+	var post models.Post
+	err := postsCollection.FindOne(context.Background(), notDeleted(bson.M{"_id": id})).Decode(&post)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve post"})
+		return
+	}
+
+	if !editPostResponse(c, &post, post.Content, append(append([]string{}, post.Tags...), normalized)) {
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "Tag added successfully"})
 }
 
-// RemoveTag removes a tag from a post
+// RemoveTag removes a tag from a post. A hashtag in the content can only go with the content.
 func RemoveTag(c *gin.Context) {
 	id, _ := primitive.ObjectIDFromHex(c.Param("id"))
 	var tag struct {
@@ -165,14 +451,31 @@ func RemoveTag(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	normalized := entities.NormalizeTag(tag.Tag)
 
-	_, err := postsCollection.UpdateOne(
-		context.Background(),
-		bson.M{"_id": id},
-		bson.M{"$pull": bson.M{"tags": tag.Tag}},
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove tag"})
+	var post models.Post
+	err := postsCollection.FindOne(context.Background(), notDeleted(bson.M{"_id": id})).Decode(&post)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve post"})
+		return
+	}
+	for _, hashtag := range entities.Hashtags(entities.Parse(post.Content)) {
+		if hashtag == normalized {
+			c.JSON(http.StatusConflict, gin.H{"error": "Tag is a hashtag in the post content"})
+			return
+		}
+	}
+
+	tags := make([]string, 0, len(post.Tags))
+	for _, existing := range post.Tags {
+		if existing != normalized {
+			tags = append(tags, existing)
+		}
+	}
+	if !editPostResponse(c, &post, post.Content, tags) {
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "Tag removed successfully"})
@@ -180,10 +483,20 @@ func RemoveTag(c *gin.Context) {
 
 // GetPostsByUser retrieves all posts by a specific user
 func GetPostsByUser(c *gin.Context) {
-	userID, _ := primitive.ObjectIDFromHex(c.Param("userID"))
+	userID, _ := primitive.ObjectIDFromHex(c.Param("id"))
+	viewer, err := requestViewer(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load viewer"})
+		return
+	}
+	if !viewer.CanSeeUser(userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
 	var posts []models.Post
 	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
-	cursor, err := postsCollection.Find(context.Background(), bson.M{"user_id": userID}, opts)
+	cursor, err := postsCollection.Find(context.Background(), notDeleted(visibleTo(viewer, bson.M{"user_id": userID})), opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve posts"})
 		return
@@ -196,3 +509,30 @@ func GetPostsByUser(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, posts)
 }
+
+// checkInteraction makes sure the request identifies a user, the post exists and the user is
+// allowed to interact with it, writing the error response when not
+func checkInteraction(c *gin.Context, postID primitive.ObjectID) (*models.Post, bool) {
+	// Blocks can only be enforced against a known user, so anonymous interactions are refused
+	if _, ok := viewerID(c); !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "X-User-ID header is required"})
+		return nil, false
+	}
+	viewer, err := requestViewer(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load viewer"})
+		return nil, false
+	}
+
+	var post models.Post
+	err = postsCollection.FindOne(context.Background(), notDeleted(bson.M{"_id": postID})).Decode(&post)
+	if err != nil || !viewer.CanSee(&post) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+		return nil, false
+	}
+	if !viewer.CanInteract(&post) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot interact with this post"})
+		return nil, false
+	}
+	return &post, true
+}
@@ -0,0 +1,348 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"feed/initializers"
+	"feed/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var followRequestsCollection *mongo.Collection = initializers.OpenCollection(initializers.Client, "follow_requests")
+
+var (
+	errAlreadyRequested = errors.New("follow already requested")
+	errNoFollowRequest  = errors.New("no pending follow request")
+)
+
+// SetAccountPrivacy makes an account private or public. The flag is copied onto the user's posts
+// and reposts of them, and going public approves every pending follow request.
+func SetAccountPrivacy(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var privacy struct {
+		IsPrivate bool `json:"is_private"`
+	}
+	if err := c.ShouldBindJSON(&privacy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var before models.User
+	err = usersCollection.FindOneAndUpdate(
+		c.Request.Context(),
+		notDeleted(bson.M{"_id": id}),
+		bson.M{"$set": bson.M{"is_private": privacy.IsPrivate}},
+	).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update privacy"})
+		return
+	}
+	if before.IsPrivate == privacy.IsPrivate {
+		c.JSON(http.StatusOK, gin.H{"message": "Privacy unchanged"})
+		return
+	}
+
+	if err = applyPostPrivacy(c.Request.Context(), id, privacy.IsPrivate); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update posts", "details": err.Error()})
+		return
+	}
+	if !privacy.IsPrivate {
+		if err = approveAllFollowRequests(c.Request.Context(), id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve follow requests", "details": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Privacy updated"})
+}
+
+// applyPostPrivacy restricts the user's posts, and other users' reposts of them, to the user's
+// followers or lifts that restriction. The change stream worker drops the cached feeds holding them.
+func applyPostPrivacy(ctx context.Context, userID primitive.ObjectID, private bool) error {
+	filter := bson.M{"$or": []bson.M{{"user_id": userID}, {"repost_of_user_id": userID}}}
+	update := bson.M{"$pull": bson.M{"private_to": userID}}
+	if private {
+		update = bson.M{"$addToSet": bson.M{"private_to": userID}}
+	}
+	_, err := postsCollection.UpdateMany(ctx, filter, update)
+	return err
+}
+
+// postAudience returns the private accounts a new post by author is restricted to,
+// starting from what it inherits from the post it shares
+func postAudience(ctx context.Context, authorID primitive.ObjectID, inherited []primitive.ObjectID) ([]primitive.ObjectID, error) {
+	audience := append([]primitive.ObjectID{}, inherited...)
+	private, err := isPrivateUser(ctx, authorID)
+	if err != nil {
+		return nil, err
+	}
+	if !private {
+		return audience, nil
+	}
+	for _, id := range audience {
+		if id == authorID {
+			return audience, nil
+		}
+	}
+	return append(audience, authorID), nil
+}
+
+// isPrivateUser reports whether the user's account is private
+func isPrivateUser(ctx context.Context, userID primitive.ObjectID) (bool, error) {
+	var user models.User
+	err := usersCollection.FindOne(
+		ctx,
+		notDeleted(bson.M{"_id": userID}),
+		options.FindOne().SetProjection(bson.M{"is_private": 1}),
+	).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	return user.IsPrivate, err
+}
+
+// addFollowRequest records a pending follow of a private account. Call it inside a transaction.
+func addFollowRequest(sc mongo.SessionContext, requesterID, targetID primitive.ObjectID) error {
+	count, err := followsCollection.CountDocuments(sc, bson.M{"follower_id": requesterID, "followee_id": targetID})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errAlreadyFollowing
+	}
+
+	_, err = followRequestsCollection.InsertOne(sc, models.FollowRequest{
+		RequesterID: requesterID,
+		TargetID:    targetID,
+		CreatedAt:   time.Now(),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return errAlreadyRequested
+	}
+	return err
+}
+
+// acceptFollowRequest turns a pending request into a follow edge and records the user.followed
+// event for it. Call it inside a transaction.
+func acceptFollowRequest(sc mongo.SessionContext, requesterID, targetID primitive.ObjectID) error {
+	result, err := followRequestsCollection.DeleteOne(sc, bson.M{"requester_id": requesterID, "target_id": targetID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errNoFollowRequest
+	}
+	err = addFollowEdge(sc, requesterID, targetID)
+	if err == errAlreadyFollowing {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return recordEvent(sc, WebhookUserFollowed, gin.H{"follower_id": requesterID, "followee_id": targetID})
+}
+
+// deleteFollowRequestPair removes pending requests between two users, in both directions
+func deleteFollowRequestPair(ctx context.Context, a, b primitive.ObjectID) error {
+	_, err := followRequestsCollection.DeleteMany(ctx, bson.M{"$or": []bson.M{
+		{"requester_id": a, "target_id": b},
+		{"requester_id": b, "target_id": a},
+	}})
+	return err
+}
+
+// requestedIDs returns the IDs of every user the given user has a pending follow request to
+func requestedIDs(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	cursor, err := followRequestsCollection.Find(
+		ctx,
+		bson.M{"requester_id": userID},
+		options.Find().SetProjection(bson.M{"target_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	ids := make([]primitive.ObjectID, 0)
+	for cursor.Next(ctx) {
+		var request models.FollowRequest
+		if err := cursor.Decode(&request); err != nil {
+			return nil, err
+		}
+		ids = append(ids, request.TargetID)
+	}
+	return ids, cursor.Err()
+}
+
+// approveAllFollowRequests accepts every pending request to the user, one transaction each
+func approveAllFollowRequests(ctx context.Context, targetID primitive.ObjectID) error {
+	for {
+		var request models.FollowRequest
+		err := followRequestsCollection.FindOne(ctx, bson.M{"target_id": targetID}).Decode(&request)
+		if err == mongo.ErrNoDocuments {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		err = withTransaction(ctx, func(sc mongo.SessionContext) error {
+			err := checkFollowPair(sc, request.RequesterID, targetID)
+			if err == errFollowerNotFound {
+				_, err = followRequestsCollection.DeleteOne(sc, bson.M{"_id": request.ID})
+				return err
+			} else if err != nil {
+				return err
+			}
+			return acceptFollowRequest(sc, request.RequesterID, targetID)
+		})
+		if err != nil && !errors.Is(err, errNoFollowRequest) {
+			return err
+		}
+		if err = invalidateFeedCache(ctx, request.RequesterID); err != nil {
+			fmt.Println("Error invalidating feed cache:", err)
+		}
+	}
+
+	return applyCelebrityPolicy(ctx, bson.M{"_id": targetID})
+}
+
+// ListFollowRequests pages through the requests waiting for the user's approval, most recent first
+func ListFollowRequests(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	limit, err := parseLimit(c, 20, 100)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit number"})
+		return
+	}
+	filter, err := cursorFilter(c.Query("cursor"), "created_at")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+	filter["target_id"] = userID
+
+	var requests []models.FollowRequest
+	cursor, err := followRequestsCollection.Find(
+		c.Request.Context(),
+		filter,
+		options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+			SetLimit(int64(limit+1)),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve follow requests"})
+		return
+	}
+	if err = cursor.All(c.Request.Context(), &requests); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode follow requests"})
+		return
+	}
+
+	nextCursor := ""
+	if len(requests) > limit {
+		requests = requests[:limit]
+		last := requests[len(requests)-1]
+		nextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+
+	ids := make([]primitive.ObjectID, len(requests))
+	for i, request := range requests {
+		ids[i] = request.RequesterID
+	}
+	summaries, err := userSummaries(c.Request.Context(), ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":       summaries,
+		"next_cursor": nextCursor,
+	})
+}
+
+// ApproveFollowRequest lets the requester follow the user
+func ApproveFollowRequest(c *gin.Context) {
+	targetID, requesterID, ok := followRequestParams(c)
+	if !ok {
+		return
+	}
+
+	err := withTransaction(c.Request.Context(), func(sc mongo.SessionContext) error {
+		if err := checkFollowPair(sc, requesterID, targetID); err != nil {
+			return err
+		}
+		return acceptFollowRequest(sc, requesterID, targetID)
+	})
+	if errors.Is(err, errNoFollowRequest) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		respondFollowError(c, err, "Failed to approve follow request")
+		return
+	}
+
+	if err = applyCelebrityPolicy(c.Request.Context(), bson.M{"_id": targetID}); err != nil {
+		fmt.Println("Error applying celebrity policy:", err)
+	}
+	if err = invalidateFeedCache(c.Request.Context(), requesterID); err != nil {
+		fmt.Println("Error invalidating feed cache:", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Follow request approved"})
+}
+
+// RejectFollowRequest drops a pending request without telling the requester
+func RejectFollowRequest(c *gin.Context) {
+	targetID, requesterID, ok := followRequestParams(c)
+	if !ok {
+		return
+	}
+
+	result, err := followRequestsCollection.DeleteOne(c.Request.Context(), bson.M{"requester_id": requesterID, "target_id": targetID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject follow request"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": errNoFollowRequest.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Follow request rejected"})
+}
+
+// followRequestParams reads the target and requester from the path, responding on failure
+func followRequestParams(c *gin.Context) (primitive.ObjectID, primitive.ObjectID, bool) {
+	targetID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+	requesterID, err := primitive.ObjectIDFromHex(c.Param("requesterID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid requester ID"})
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+	return targetID, requesterID, true
+}
@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"feed/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// postPurgeBatchSize bounds how many posts a single purge pass loads at once
+const postPurgeBatchSize = 100
+
+// StartPostPurger runs PurgeDeletedPosts on the given interval until ctx is cancelled
+func StartPostPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		heartbeat("post_purger", interval)
+		purged, err := PurgeDeletedPosts(ctx)
+		if err != nil {
+			fmt.Println("Error purging deleted posts:", err)
+		} else if purged > 0 {
+			fmt.Printf("Purged %d deleted posts\n", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeDeletedPosts permanently removes posts whose retention window has passed.
+// Every reference is scrubbed before the post itself goes, so an interrupted run
+// simply picks the same posts up again next time.
+func PurgeDeletedPosts(ctx context.Context) (int, error) {
+	cutoff := time.Now().Add(-deps.PostRetention)
+	purged := 0
+
+	for {
+		cursor, err := postsCollection.Find(
+			ctx,
+			bson.M{"deleted_at": bson.M{"$lt": cutoff}},
+			options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(postPurgeBatchSize),
+		)
+		if err != nil {
+			return purged, err
+		}
+		var posts []models.Post
+		if err = cursor.All(ctx, &posts); err != nil {
+			return purged, err
+		}
+		if len(posts) == 0 {
+			return purged, nil
+		}
+
+		postIDs := make([]primitive.ObjectID, len(posts))
+		for i, post := range posts {
+			postIDs[i] = post.ID
+		}
+		if err = purgePosts(ctx, postIDs); err != nil {
+			return purged, err
+		}
+		purged += len(postIDs)
+	}
+}
+
+// purgePosts removes the posts and everything that points at them. Quote posts of them are
+// kept, they are their authors' own words, and are served without the quoted original.
+func purgePosts(ctx context.Context, postIDs []primitive.ObjectID) error {
+	// Cached pages are dropped before the feeds are rewritten, while we can still tell whose they are
+	if err := invalidateFeedsContaining(ctx, postIDs); err != nil {
+		return err
+	}
+	if err := forgetTrendingPosts(ctx, postIDs); err != nil {
+		return err
+	}
+
+	_, err := feedsCollection.UpdateMany(
+		ctx,
+		bson.M{"posts": bson.M{"$in": postIDs}},
+		bson.M{"$pull": bson.M{"posts": bson.M{"$in": postIDs}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if _, err = postRevisionsCollection.DeleteMany(ctx, bson.M{"post_id": bson.M{"$in": postIDs}}); err != nil {
+		return err
+	}
+	if _, err = notificationsCollection.DeleteMany(ctx, bson.M{"post_id": bson.M{"$in": postIDs}}); err != nil {
+		return err
+	}
+	if _, err = likesCollection.DeleteMany(ctx, bson.M{"post_id": bson.M{"$in": postIDs}}); err != nil {
+		return err
+	}
+
+	_, err = postsCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": postIDs}})
+	return err
+}
@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"feed/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RepostPost shares a post on the user's timeline, optionally with quote content
+func RepostPost(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	postID, err := primitive.ObjectIDFromHex(c.Param("postID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid post ID"})
+		return
+	}
+
+	// The body is optional, a plain repost has no content
+	var quote struct {
+		Content string `json:"content"`
+	}
+	if err := c.ShouldBindJSON(&quote); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Accounts being deleted are soft deleted first, so they can't repost either
+	err = usersCollection.FindOne(context.Background(), notDeleted(bson.M{"_id": userID})).Err()
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		return
+	}
+
+	var target models.Post
+	err = postsCollection.FindOne(context.Background(), notDeleted(bson.M{"_id": postID})).Decode(&target)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve post"})
+		}
+		return
+	}
+
+	viewer, err := loadViewer(context.Background(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load viewer"})
+		return
+	}
+	if !viewer.CanInteract(&target) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+		return
+	}
+	if target.Visibility != "" && target.Visibility != models.VisibilityPublic {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only public posts can be reposted"})
+		return
+	}
+
+	// Reposting a plain repost shares the original instead
+	originalID, originalUserID := target.ID, target.UserID
+	if target.IsRepost() {
+		originalID = *target.RepostOf
+		if target.RepostOfUserID != nil {
+			originalUserID = *target.RepostOfUserID
+		}
+	}
+
+	if quote.Content == "" {
+		count, err := postsCollection.CountDocuments(context.Background(), notDeleted(bson.M{
+			"user_id":   userID,
+			"repost_of": originalID,
+			"content":   "",
+		}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check existing reposts"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Post already reposted"})
+			return
+		}
+	}
+
+	// A repost never reaches further than what it shares
+	audience, err := postAudience(context.Background(), userID, target.PrivateTo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve author"})
+		return
+	}
+
+	repost := models.Post{
+		UserID:         userID,
+		Content:        quote.Content,
+		RepostOf:       &originalID,
+		RepostOfUserID: &originalUserID,
+		PrivateTo:      audience,
+		CreatedAt:      time.Now(),
+	}
+	if err = applyEntities(context.Background(), &repost); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve mentions"})
+		return
+	}
+	repost.ID = primitive.NewObjectID()
+	err = withTransaction(context.Background(), func(sc mongo.SessionContext) error {
+		if _, err := postsCollection.InsertOne(sc, repost); err != nil {
+			return err
+		}
+		if err := updateRepostCount(sc, &repost, 1); err != nil {
+			return err
+		}
+		return recordEvent(sc, WebhookPostCreated, gin.H{"post": repost})
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		// Lost the race against the same plain repost, the unique index caught it
+		c.JSON(http.StatusConflict, gin.H{"error": "Post already reposted"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create repost"})
+		return
+	}
+	notifyMentions(context.Background(), &repost, nil)
+	recordTagUse(context.Background(), &repost, repost.Tags)
+
+	c.JSON(http.StatusCreated, repost)
+}
+
+// UndoRepost soft deletes the user's plain repost of a post. Quote posts are removed through DeletePost.
+// Like DeletePost it records no event: webhooks only announce new posts, there is no deletion event.
+func UndoRepost(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	postID, err := primitive.ObjectIDFromHex(c.Param("postID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid post ID"})
+		return
+	}
+
+	// The ID may be the original or the user's repost of it
+	var target models.Post
+	err = postsCollection.FindOne(context.Background(), bson.M{"_id": postID}).Decode(&target)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Repost not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve post"})
+		return
+	}
+	if target.IsRepost() {
+		postID = *target.RepostOf
+	}
+
+	// Soft deleted like any post, so it leaves feeds through the purge job
+	err = withTransaction(context.Background(), func(sc mongo.SessionContext) error {
+		var repost models.Post
+		err := postsCollection.FindOneAndUpdate(
+			sc,
+			notDeleted(bson.M{
+				"user_id":   userID,
+				"repost_of": postID,
+				"content":   "",
+			}),
+			bson.M{"$set": bson.M{"deleted_at": time.Now()}},
+		).Decode(&repost)
+		if err != nil {
+			return err
+		}
+		return updateRepostCount(sc, &repost, -1)
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Repost not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to undo repost"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Repost removed successfully"})
+}
@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"feed/initializers"
+	"feed/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var postRevisionsCollection *mongo.Collection = initializers.OpenCollection(initializers.Client, "post_revision")
+
+// errEditWindowClosed is returned for edits to a post older than deps.PostEditWindow
+var errEditWindowClosed = errors.New("post can no longer be edited")
+
+// editPost is the one way a post's content and tags change. Mentions and hashtags are re-derived
+// from the edit, and the previous version is stored as a revision in the same transaction as the
+// update. It returns the edited post and whether its content or tags changed, an edit leaving
+// them as they were records nothing.
+func editPost(ctx context.Context, post *models.Post, content string, tags []string, editorID primitive.ObjectID) (*models.Post, bool, error) {
+	if deps.PostEditWindow > 0 && time.Since(post.CreatedAt) > deps.PostEditWindow {
+		return nil, false, errEditWindowClosed
+	}
+
+	edited := *post
+	edited.Content = content
+	edited.Tags = tags
+	if err := applyEntities(ctx, &edited); err != nil {
+		return nil, false, err
+	}
+
+	if edited.Content == post.Content && sameTags(edited.Tags, post.Tags) {
+		return &edited, false, nil
+	}
+	now := time.Now()
+	edited.EditedAt = &now
+	set := bson.M{
+		"content":   edited.Content,
+		"tags":      edited.Tags,
+		"entities":  edited.Entities,
+		"mentions":  edited.Mentions,
+		"edited_at": now,
+	}
+
+	err := withTransaction(ctx, func(sc mongo.SessionContext) error {
+		if err := recordRevision(sc, post, editorID, now); err != nil {
+			return err
+		}
+		result, err := postsCollection.UpdateOne(sc, notDeleted(bson.M{"_id": post.ID}), bson.M{"$set": set})
+		if err == nil && result.MatchedCount == 0 {
+			err = mongo.ErrNoDocuments
+		}
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Only users newly mentioned and tags newly added by the edit count again
+	notifyMentions(ctx, &edited, post.Mentions)
+	recordTagUse(ctx, &edited, addedTags(post.Tags, edited.Tags))
+	return &edited, true, nil
+}
+
+// sameTags reports whether both lists hold the same tags, in any order
+func sameTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	return len(addedTags(a, b)) == 0 && len(addedTags(b, a)) == 0
+}
+
+// recordRevision stores the post's current content and tags before they are overwritten
+func recordRevision(ctx context.Context, post *models.Post, editorID primitive.ObjectID, editedAt time.Time) error {
+	_, err := postRevisionsCollection.InsertOne(ctx, models.PostRevision{
+		PostID:   post.ID,
+		EditorID: editorID,
+		Content:  post.Content,
+		Tags:     post.Tags,
+		EditedAt: editedAt,
+	})
+	return err
+}
+
+// GetPostRevisions lists the previous versions of a post, newest first, to those who can see it
+func GetPostRevisions(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid post ID"})
+		return
+	}
+
+	viewer, err := requestViewer(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load viewer"})
+		return
+	}
+
+	// The history is only shown to those who may see the post itself
+	var post models.Post
+	err = postsCollection.FindOne(context.Background(), notDeleted(bson.M{"_id": id})).Decode(&post)
+	if err == mongo.ErrNoDocuments || (err == nil && !viewer.CanSee(&post)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve post"})
+		return
+	}
+
+	revisions := []models.PostRevision{}
+	opts := options.Find().SetSort(bson.D{{Key: "edited_at", Value: -1}})
+	cursor, err := postRevisionsCollection.Find(context.Background(), bson.M{"post_id": id}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve revisions"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	if err = cursor.All(context.Background(), &revisions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode revisions"})
+		return
+	}
+	c.JSON(http.StatusOK, revisions)
+}
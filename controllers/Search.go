@@ -0,0 +1,265 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"feed/entities"
+	"feed/models"
+	"feed/visibility"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Search orders
+const (
+	SearchByRelevance = "relevance"
+	SearchByRecency   = "recent"
+)
+
+// maxSearchQueryLength bounds the text handed to the index
+const maxSearchQueryLength = 200
+
+// maxSearchOffset is how deep relevance results can be paged
+const maxSearchOffset = 1000
+
+// SearchQuery describes one page of a post search
+type SearchQuery struct {
+	Text     string
+	AuthorID *primitive.ObjectID
+	Tag      string
+	Since    *time.Time
+	Until    *time.Time
+	Sort     string
+	Cursor   string
+	Limit    int
+	Viewer   *visibility.Viewer
+}
+
+// SearchPage is one page of results and the cursor of the next one, empty on the last page
+type SearchPage struct {
+	Posts      []models.Post
+	NextCursor string
+}
+
+// SearchIndex finds posts and users matching a query, leaving out what the viewer may not see.
+// Cursors are opaque to callers and only valid for the index that issued them.
+type SearchIndex interface {
+	Search(ctx context.Context, query SearchQuery) (*SearchPage, error)
+	// SearchUsers returns the best matches on username and bio, up to query.Limit of them
+	SearchUsers(ctx context.Context, query SearchQuery) ([]models.UserSummary, error)
+}
+
+// SearchIndexer is implemented by indexes that keep their own copy of posts and users. The change
+// stream worker keeps them up to date, the Mongo index reads the collections directly and doesn't
+// need it.
+type SearchIndexer interface {
+	IndexPost(ctx context.Context, post *models.Post) error
+	RemovePost(ctx context.Context, id primitive.ObjectID) error
+	IndexUser(ctx context.Context, user *models.User) error
+	RemoveUser(ctx context.Context, id primitive.ObjectID) error
+}
+
+// searchIndex serves GET /search. Swap it to move search to a dedicated engine.
+var searchIndex SearchIndex = mongoSearchIndex{}
+
+// SearchPosts searches post content and tags, ?q= with optional author, tag, since, until and sort.
+// The first page also lists the users whose username or bio match.
+func SearchPosts(c *gin.Context) {
+	query := SearchQuery{
+		Text:   c.Query("q"),
+		Tag:    entities.NormalizeTag(c.Query("tag")),
+		Sort:   c.DefaultQuery("sort", SearchByRelevance),
+		Cursor: c.Query("cursor"),
+	}
+	if query.Text == "" || len(query.Text) > maxSearchQueryLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q must be between 1 and 200 characters"})
+		return
+	}
+	if query.Sort != SearchByRelevance && query.Sort != SearchByRecency {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort, use relevance or recent"})
+		return
+	}
+	if author := c.Query("author"); author != "" {
+		id, err := primitive.ObjectIDFromHex(author)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid author ID"})
+			return
+		}
+		query.AuthorID = &id
+	}
+	for param, target := range map[string]**time.Time{"since": &query.Since, "until": &query.Until} {
+		if value := c.Query(param); value != "" {
+			at, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param + ", use RFC 3339"})
+				return
+			}
+			*target = &at
+		}
+	}
+
+	limit, err := parseLimit(c, 20, 100)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit number"})
+		return
+	}
+	query.Limit = limit
+
+	query.Viewer, err = requestViewer(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load viewer"})
+		return
+	}
+
+	page, err := searchIndex.Search(c.Request.Context(), query)
+	if errors.Is(err, errInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Search failed"})
+		return
+	}
+
+	response := gin.H{
+		"posts":       page.Posts,
+		"next_cursor": page.NextCursor,
+	}
+	if query.Cursor == "" {
+		users, err := searchIndex.SearchUsers(c.Request.Context(), query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Search failed"})
+			return
+		}
+		response["users"] = users
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// mongoSearchIndex searches with the text index on post content and tags. Recency pages use the
+// usual time cursor, relevance pages an offset since text scores can't be resumed from.
+type mongoSearchIndex struct{}
+
+func (mongoSearchIndex) Search(ctx context.Context, query SearchQuery) (*SearchPage, error) {
+	filter := bson.M{"$text": bson.M{"$search": query.Text}}
+	if query.AuthorID != nil {
+		filter["user_id"] = *query.AuthorID
+	}
+	if query.Tag != "" {
+		filter["tags"] = query.Tag
+	}
+	created := bson.M{}
+	if query.Since != nil {
+		created["$gte"] = *query.Since
+	}
+	if query.Until != nil {
+		created["$lt"] = *query.Until
+	}
+	if len(created) > 0 {
+		filter["created_at"] = created
+	}
+
+	opts := options.Find().SetLimit(int64(query.Limit + 1))
+	offset := 0
+	if query.Sort == SearchByRecency {
+		after, err := cursorFilter(query.Cursor, "created_at")
+		if err != nil {
+			return nil, err
+		}
+		filter = bson.M{"$and": []bson.M{filter, after}}
+		opts.SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+	} else {
+		var err error
+		if offset, err = decodeOffsetCursor(query.Cursor); err != nil {
+			return nil, err
+		}
+		score := bson.M{"$meta": "textScore"}
+		opts.SetProjection(bson.M{"score": score}).
+			SetSort(bson.D{{Key: "score", Value: score}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+			SetSkip(int64(offset))
+	}
+
+	posts := []models.Post{}
+	cursor, err := postsCollection.Find(ctx, notDeleted(visibleTo(query.Viewer, filter)), opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &posts); err != nil {
+		return nil, err
+	}
+
+	page := &SearchPage{Posts: posts}
+	if len(posts) > query.Limit {
+		page.Posts = posts[:query.Limit]
+		last := page.Posts[len(page.Posts)-1]
+		if query.Sort == SearchByRecency {
+			page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+		} else if next := offset + query.Limit; next <= maxSearchOffset {
+			page.NextCursor = encodeOffsetCursor(next)
+		}
+	}
+	return page, nil
+}
+
+// SearchUsers matches the text index on username and bio, leaving out deleted users and those
+// in a block relationship with the viewer
+func (mongoSearchIndex) SearchUsers(ctx context.Context, query SearchQuery) ([]models.UserSummary, error) {
+	filter := bson.M{"$text": bson.M{"$search": query.Text}}
+	if blocked := query.Viewer.BlockedIDs(); len(blocked) > 0 {
+		filter["_id"] = bson.M{"$nin": blocked}
+	}
+	score := bson.M{"$meta": "textScore"}
+	var users []models.User
+	cursor, err := usersCollection.Find(
+		ctx,
+		notDeleted(filter),
+		options.Find().
+			SetProjection(bson.M{"score": score, "username": 1, "bio": 1, "is_celebrity": 1, "is_private": 1}).
+			SetSort(bson.D{{Key: "score", Value: score}, {Key: "follower_count", Value: -1}}).
+			SetLimit(int64(query.Limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	summaries := make([]models.UserSummary, len(users))
+	for i, user := range users {
+		summaries[i] = models.UserSummary{
+			ID:          user.ID,
+			Username:    user.Username,
+			Bio:         user.Bio,
+			IsCelebrity: user.IsCelebrity,
+			IsPrivate:   user.IsPrivate,
+		}
+	}
+	return summaries, nil
+}
+
+func encodeOffsetCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func decodeOffsetCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errInvalidCursor
+	}
+	offset, err := strconv.Atoi(string(raw))
+	if err != nil || offset < 0 || offset > maxSearchOffset {
+		return 0, errInvalidCursor
+	}
+	return offset, nil
+}
@@ -0,0 +1,336 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"feed/models"
+	"feed/suggestions"
+	"feed/visibility"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Candidate pool sizes. Each source is capped, the merged pool is what gets cached per user.
+const (
+	maxSuggestionCandidates     = 200
+	popularSuggestionCandidates = 50
+)
+
+// Suggestion is an account the user might want to follow and why
+type Suggestion struct {
+	models.UserSummary
+	Mutuals    int     `json:"mutuals"`
+	SharedTags int     `json:"shared_tags"`
+	Score      float64 `json:"score"`
+}
+
+// GetSuggestions lists accounts for the user to follow, ranked by mutual follows, shared tags and popularity
+func GetSuggestions(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	limit, err := parseLimit(c, 20, 50)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit number"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	// Only real users get a cache entry, so random IDs can't fill Redis
+	count, err := usersCollection.CountDocuments(ctx, notDeleted(bson.M{"_id": userID}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	candidates, err := cachedSuggestions(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load suggestions"})
+		return
+	}
+
+	// The cache can be hours old, so follows, blocks and requests made since are taken out here
+	viewer, err := loadViewer(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load viewer"})
+		return
+	}
+	exclude, err := suggestionExclusions(ctx, viewer)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load follow requests"})
+		return
+	}
+	ranked := suggestions.Rank(candidates, exclude, limit)
+
+	ids := make([]primitive.ObjectID, len(ranked))
+	for i, candidate := range ranked {
+		ids[i] = candidate.UserID
+	}
+	summaries, err := userSummaries(ctx, ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve users"})
+		return
+	}
+	byID := make(map[primitive.ObjectID]suggestions.Candidate, len(ranked))
+	for _, candidate := range ranked {
+		byID[candidate.UserID] = candidate
+	}
+
+	result := make([]Suggestion, len(summaries))
+	for i, summary := range summaries {
+		candidate := byID[summary.ID]
+		result[i] = Suggestion{
+			UserSummary: summary,
+			Mutuals:     candidate.Mutuals,
+			SharedTags:  candidate.SharedTags,
+			Score:       candidate.Score,
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"users": result})
+}
+
+// StartSuggestionBuilder recomputes every user's suggestions on the given interval until ctx is cancelled
+func StartSuggestionBuilder(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		heartbeat("suggestion_builder", interval)
+		built, err := RefreshSuggestions(ctx)
+		if err != nil {
+			fmt.Println("Error building suggestions:", err)
+		} else if built > 0 {
+			fmt.Printf("Built suggestions for %d users\n", built)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RefreshSuggestions recomputes and caches the suggestions of every user. A failing user is
+// reported and skipped so one bad account doesn't stop the run.
+func RefreshSuggestions(ctx context.Context) (int, error) {
+	cursor, err := usersCollection.Find(ctx, notDeleted(bson.M{}), options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	built := 0
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return built, err
+		}
+		if _, err := buildSuggestions(ctx, user.ID); err != nil {
+			fmt.Println("Error building suggestions of", user.ID.Hex()+":", err)
+			continue
+		}
+		built++
+	}
+	return built, cursor.Err()
+}
+
+// cachedSuggestions returns the user's precomputed candidates, building them now if the builder
+// hasn't reached the user yet, as happens for accounts created since its last run
+func cachedSuggestions(ctx context.Context, userID primitive.ObjectID) ([]suggestions.Candidate, error) {
+	cached, err := deps.Redis.Get(ctx, suggestionsKey(userID)).Result()
+	if err == redis.Nil {
+		return buildSuggestions(ctx, userID)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var candidates []suggestions.Candidate
+	if err := json.Unmarshal([]byte(cached), &candidates); err != nil {
+		return buildSuggestions(ctx, userID)
+	}
+	return candidates, nil
+}
+
+// buildSuggestions gathers the user's candidates from every source and caches the best of them
+func buildSuggestions(ctx context.Context, userID primitive.ObjectID) ([]suggestions.Candidate, error) {
+	viewer, err := loadViewer(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	following := viewer.FollowingIDs()
+	exclude, err := suggestionExclusions(ctx, viewer)
+	if err != nil {
+		return nil, err
+	}
+	known := make([]primitive.ObjectID, 0, len(exclude))
+	for id := range exclude {
+		known = append(known, id)
+	}
+
+	mutuals, err := mutualCandidates(ctx, following, known)
+	if err != nil {
+		return nil, err
+	}
+	tagged, err := sharedTagCandidates(ctx, userID, known)
+	if err != nil {
+		return nil, err
+	}
+	popular, err := popularCandidates(ctx, known)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates, err := withFollowerCounts(ctx, suggestions.Merge(mutuals, tagged, popular))
+	if err != nil {
+		return nil, err
+	}
+	candidates = suggestions.Rank(candidates, exclude, maxSuggestionCandidates)
+
+	serialized, err := json.Marshal(candidates)
+	if err != nil {
+		return nil, err
+	}
+	if err := deps.Redis.Set(ctx, suggestionsKey(userID), serialized, deps.SuggestionTTL).Err(); err != nil {
+		fmt.Println("Error caching suggestions:", err)
+	}
+	return candidates, nil
+}
+
+// suggestionExclusions returns the accounts the viewer follows, is in a block with or has a
+// pending follow request to, along with the viewer, none of which are suggested
+func suggestionExclusions(ctx context.Context, viewer *visibility.Viewer) (map[primitive.ObjectID]bool, error) {
+	requested, err := requestedIDs(ctx, viewer.ID)
+	if err != nil {
+		return nil, err
+	}
+	return suggestions.Exclusions(viewer.ID, viewer.FollowingIDs(), viewer.BlockedIDs(), requested), nil
+}
+
+// mutualCandidates returns the accounts followed by the people the user follows, with how many of them do
+func mutualCandidates(ctx context.Context, following, known []primitive.ObjectID) ([]suggestions.Candidate, error) {
+	if len(following) == 0 {
+		return nil, nil
+	}
+	return groupedCandidates(ctx, followsCollection, bson.M{
+		"follower_id": bson.M{"$in": following},
+		"followee_id": bson.M{"$nin": known},
+	}, "$followee_id", func(c *suggestions.Candidate, n int) { c.Mutuals = n })
+}
+
+// sharedTagCandidates returns the accounts following the same tags as the user, with how many they share
+func sharedTagCandidates(ctx context.Context, userID primitive.ObjectID, known []primitive.ObjectID) ([]suggestions.Candidate, error) {
+	tags, err := followedTags(ctx, userID)
+	if err != nil || len(tags) == 0 {
+		return nil, err
+	}
+	return groupedCandidates(ctx, tagFollowsCollection, bson.M{
+		"tag":     bson.M{"$in": tags},
+		"user_id": bson.M{"$nin": known},
+	}, "$user_id", func(c *suggestions.Candidate, n int) { c.SharedTags = n })
+}
+
+// groupedCandidates counts the matching documents per user and keeps the users with the most
+func groupedCandidates(ctx context.Context, collection *mongo.Collection, match bson.M, userField string, set func(*suggestions.Candidate, int)) ([]suggestions.Candidate, error) {
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{"_id": userField, "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: maxSuggestionCandidates}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var groups []struct {
+		UserID primitive.ObjectID `bson:"_id"`
+		Count  int                `bson:"count"`
+	}
+	if err = cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	candidates := make([]suggestions.Candidate, len(groups))
+	for i, group := range groups {
+		candidates[i].UserID = group.UserID
+		set(&candidates[i], group.Count)
+	}
+	return candidates, nil
+}
+
+// popularCandidates returns the most followed accounts, so users without follows or tags still get suggestions
+func popularCandidates(ctx context.Context, known []primitive.ObjectID) ([]suggestions.Candidate, error) {
+	var users []models.User
+	cursor, err := usersCollection.Find(
+		ctx,
+		notDeleted(bson.M{"_id": bson.M{"$nin": known}}),
+		options.Find().
+			SetSort(bson.D{{Key: "follower_count", Value: -1}}).
+			SetLimit(popularSuggestionCandidates).
+			SetProjection(bson.M{"follower_count": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	candidates := make([]suggestions.Candidate, len(users))
+	for i, user := range users {
+		candidates[i] = suggestions.Candidate{UserID: user.ID, Followers: user.FollowerCount}
+	}
+	return candidates, nil
+}
+
+// withFollowerCounts fills in each candidate's follower count, dropping the ones that no longer exist
+func withFollowerCounts(ctx context.Context, candidates []suggestions.Candidate) ([]suggestions.Candidate, error) {
+	ids := make([]primitive.ObjectID, len(candidates))
+	for i, candidate := range candidates {
+		ids[i] = candidate.UserID
+	}
+
+	var users []models.User
+	cursor, err := usersCollection.Find(
+		ctx,
+		notDeleted(bson.M{"_id": bson.M{"$in": ids}}),
+		options.Find().SetProjection(bson.M{"follower_count": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	followers := make(map[primitive.ObjectID]int, len(users))
+	for _, user := range users {
+		followers[user.ID] = user.FollowerCount
+	}
+
+	existing := make([]suggestions.Candidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		if count, ok := followers[candidate.UserID]; ok {
+			candidate.Followers = count
+			existing = append(existing, candidate)
+		}
+	}
+	return existing, nil
+}
+
+func suggestionsKey(userID primitive.ObjectID) string {
+	return fmt.Sprintf("suggestions:%s", userID.Hex())
+}
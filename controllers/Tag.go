@@ -0,0 +1,256 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"feed/entities"
+	"feed/initializers"
+	"feed/models"
+	"feed/visibility"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var tagFollowsCollection *mongo.Collection = initializers.OpenCollection(initializers.Client, "tag_follows")
+
+// maxFollowedTags caps how many tags one user can follow, each one widens their feed query
+const maxFollowedTags = 200
+
+// maxListedTags is how many of the most used tags are counted and cached
+const maxListedTags = 200
+
+// tagCountsKey caches the most used tags. They are counted over public posts so every viewer
+// shares one entry.
+const tagCountsKey = "tags:counts"
+
+// TagCount is a tag with the number of public posts carrying it
+type TagCount struct {
+	Tag   string `bson:"_id" json:"tag"`
+	Count int    `bson:"count" json:"count"`
+}
+
+// ListTags lists the tags in use, most used first
+func ListTags(c *gin.Context) {
+	limit, err := parseLimit(c, 50, maxListedTags)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit number"})
+		return
+	}
+
+	tags, err := cachedTagCounts(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count tags"})
+		return
+	}
+	if len(tags) > limit {
+		tags = tags[:limit]
+	}
+	c.JSON(http.StatusOK, tags)
+}
+
+// cachedTagCounts returns the most used tags from the cache, counting them on a miss
+func cachedTagCounts(ctx context.Context) ([]TagCount, error) {
+	tags := []TagCount{}
+	cached, err := deps.Redis.Get(ctx, tagCountsKey).Result()
+	if err == nil && json.Unmarshal([]byte(cached), &tags) == nil {
+		return tags, nil
+	}
+
+	cursor, err := postsCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: notDeleted(visibleTo(visibility.Anonymous(), bson.M{"tags.0": bson.M{"$exists": true}}))}},
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$group", Value: bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: maxListedTags}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &tags); err != nil {
+		return nil, err
+	}
+
+	serialized, err := json.Marshal(tags)
+	if err == nil {
+		err = deps.Redis.Set(ctx, tagCountsKey, serialized, deps.TagCountTTL).Err()
+	}
+	if err != nil {
+		fmt.Println("Error caching tag counts:", err)
+	}
+	return tags, nil
+}
+
+// GetTagPosts pages through the posts carrying a tag, most recent first
+func GetTagPosts(c *gin.Context) {
+	tag := entities.NormalizeTag(c.Param("tag"))
+	if tag == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tag"})
+		return
+	}
+	limit, err := parseLimit(c, 20, 100)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit number"})
+		return
+	}
+	filter, err := cursorFilter(c.Query("cursor"), "created_at")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+	filter["tags"] = tag
+
+	viewer, err := requestViewer(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load viewer"})
+		return
+	}
+
+	posts := []models.Post{}
+	cursor, err := postsCollection.Find(
+		c.Request.Context(),
+		notDeleted(visibleTo(viewer, filter)),
+		options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+			SetLimit(int64(limit+1)),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve posts"})
+		return
+	}
+	if err = cursor.All(c.Request.Context(), &posts); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode posts"})
+		return
+	}
+
+	nextCursor := ""
+	if len(posts) > limit {
+		posts = posts[:limit]
+		last := posts[len(posts)-1]
+		nextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"posts":       posts,
+		"next_cursor": nextCursor,
+	})
+}
+
+// FollowTag adds a tag's posts to the user's feed
+func FollowTag(c *gin.Context) {
+	userID, tag, ok := tagFollowParams(c)
+	if !ok {
+		return
+	}
+
+	count, err := tagFollowsCollection.CountDocuments(c.Request.Context(), bson.M{"user_id": userID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to follow tag"})
+		return
+	}
+	if count >= maxFollowedTags {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Users can follow at most %d tags", maxFollowedTags)})
+		return
+	}
+
+	_, err = tagFollowsCollection.InsertOne(c.Request.Context(), models.TagFollow{
+		UserID:    userID,
+		Tag:       tag,
+		CreatedAt: time.Now(),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Already following tag"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to follow tag"})
+		return
+	}
+
+	if err = invalidateFeedCache(c.Request.Context(), userID); err != nil {
+		fmt.Println("Error invalidating feed cache:", err)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Successfully followed tag"})
+}
+
+// UnfollowTag stops a tag's posts from reaching the user's feed
+func UnfollowTag(c *gin.Context) {
+	userID, tag, ok := tagFollowParams(c)
+	if !ok {
+		return
+	}
+
+	result, err := tagFollowsCollection.DeleteOne(c.Request.Context(), bson.M{"user_id": userID, "tag": tag})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unfollow tag"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not following tag"})
+		return
+	}
+
+	if err = invalidateFeedCache(c.Request.Context(), userID); err != nil {
+		fmt.Println("Error invalidating feed cache:", err)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Successfully unfollowed tag"})
+}
+
+// ListFollowedTags lists the tags a user follows, most recent first
+func ListFollowedTags(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	tags, err := followedTags(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve followed tags"})
+		return
+	}
+	c.JSON(http.StatusOK, tags)
+}
+
+// followedTags returns the tags the user follows
+func followedTags(ctx context.Context, userID primitive.ObjectID) ([]string, error) {
+	var follows []models.TagFollow
+	cursor, err := tagFollowsCollection.Find(
+		ctx,
+		bson.M{"user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &follows); err != nil {
+		return nil, err
+	}
+
+	tags := make([]string, len(follows))
+	for i, follow := range follows {
+		tags[i] = follow.Tag
+	}
+	return tags, nil
+}
+
+// tagFollowParams reads the user and normalized tag from the path, responding on failure
+func tagFollowParams(c *gin.Context) (primitive.ObjectID, string, bool) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return primitive.NilObjectID, "", false
+	}
+	tag := entities.NormalizeTag(c.Param("tag"))
+	if tag == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tag"})
+		return primitive.NilObjectID, "", false
+	}
+	return userID, tag, true
+}
@@ -0,0 +1,23 @@
+package controllers
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// withTransaction runs fn in a multi-document transaction. The driver retries the whole
+// transaction on TransientTransactionError and the commit on UnknownTransactionCommitResult.
+// Transactions need MongoDB to run as a replica set.
+func withTransaction(ctx context.Context, fn func(sc mongo.SessionContext) error) error {
+	session, err := deps.Mongo.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
@@ -0,0 +1,291 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"feed/models"
+	"feed/trending"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// trendingRankTTL is how long a computed ranking is served before it is recomputed
+const trendingRankTTL = time.Minute
+
+// TrendingTag is a tag with its decayed score and how many authors used it in the window
+type TrendingTag struct {
+	Tag     string  `json:"tag"`
+	Score   float64 `json:"score"`
+	Authors int64   `json:"authors"`
+}
+
+// TrendingPost is a post with its decayed like score
+type TrendingPost struct {
+	Post  models.Post `json:"post"`
+	Score float64     `json:"score"`
+}
+
+// GetTrendingTags serves the top tags of a window, ?window=1h|24h|7d
+func GetTrendingTags(c *gin.Context) {
+	window, limit, ok := trendingParams(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	// Fetch extra candidates since some fail the author diversity rule
+	ranked, err := rankedMembers(ctx, "tags", window, int64(limit*3))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rank tags"})
+		return
+	}
+
+	tags := make([]TrendingTag, 0, limit)
+	for _, member := range ranked {
+		if len(tags) == limit {
+			break
+		}
+		tag := member.Member.(string)
+		authors, err := tagAuthorCount(ctx, window, tag)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count tag authors"})
+			return
+		}
+		if authors < int64(deps.TrendingMinTagAuthors) {
+			continue
+		}
+		tags = append(tags, TrendingTag{Tag: tag, Score: member.Score, Authors: authors})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"window": window.Name, "tags": tags})
+}
+
+// GetTrendingPosts serves the posts with the most like velocity in a window that the viewer may see
+func GetTrendingPosts(c *gin.Context) {
+	window, limit, ok := trendingParams(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	viewer, err := requestViewer(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load viewer"})
+		return
+	}
+
+	ranked, err := rankedMembers(ctx, "posts", window, int64(limit*5))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rank posts"})
+		return
+	}
+	scores := make(map[primitive.ObjectID]float64, len(ranked))
+	ids := make([]primitive.ObjectID, 0, len(ranked))
+	for _, member := range ranked {
+		id, err := primitive.ObjectIDFromHex(member.Member.(string))
+		if err != nil || member.Score <= 0 {
+			continue
+		}
+		scores[id] = member.Score
+		ids = append(ids, id)
+	}
+
+	var posts []models.Post
+	cursor, err := postsCollection.Find(ctx, notDeleted(visibleTo(viewer, bson.M{"_id": bson.M{"$in": ids}})))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve posts"})
+		return
+	}
+	if err = cursor.All(ctx, &posts); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode posts"})
+		return
+	}
+
+	postsByID := make(map[primitive.ObjectID]models.Post, len(posts))
+	candidates := make([]trending.Candidate, 0, len(posts))
+	for _, post := range posts {
+		if !viewer.CanSee(&post) {
+			continue
+		}
+		postsByID[post.ID] = post
+		candidates = append(candidates, trending.Candidate{ID: post.ID, AuthorID: post.UserID, Score: scores[post.ID]})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Score > candidates[j].Score })
+
+	result := make([]TrendingPost, 0, limit)
+	for _, candidate := range trending.LimitPerAuthor(candidates, deps.TrendingMaxPostsPerAuthor, limit) {
+		result = append(result, TrendingPost{Post: postsByID[candidate.ID], Score: candidate.Score})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"window": window.Name, "posts": result})
+}
+
+// trendingParams reads the window and limit, responding on failure
+func trendingParams(c *gin.Context) (trending.Window, int, bool) {
+	window, ok := trending.ParseWindow(c.DefaultQuery("window", "24h"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid window, use 1h, 24h or 7d"})
+		return trending.Window{}, 0, false
+	}
+	limit, err := parseLimit(c, 20, 100)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit number"})
+		return trending.Window{}, 0, false
+	}
+	return window, limit, true
+}
+
+// recordTagUse counts the post's tags towards trending. Each author counts once per tag and bucket,
+// so repeating a tag raises its score no more than using it once.
+func recordTagUse(ctx context.Context, post *models.Post, tags []string) {
+	if !countsTowardTrending(post) || len(tags) == 0 {
+		return
+	}
+	now := time.Now()
+
+	type use struct {
+		window trending.Window
+		tag    string
+		added  *redis.IntCmd
+	}
+	uses := make([]use, 0, len(trending.Windows)*len(tags))
+	pipe := deps.Redis.Pipeline()
+	for _, window := range trending.Windows {
+		start := window.BucketStart(now)
+		for _, tag := range tags {
+			key := tagAuthorsKey(window, start, tag)
+			uses = append(uses, use{window, tag, pipe.PFAdd(ctx, key, post.UserID.Hex())})
+			pipe.Expire(ctx, key, window.Span+window.Bucket)
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		fmt.Println("Error recording tag use:", err)
+		return
+	}
+
+	pipe = deps.Redis.Pipeline()
+	for _, u := range uses {
+		if u.added.Val() == 0 {
+			continue
+		}
+		key := bucketKey("tags", u.window, u.window.BucketStart(now))
+		pipe.ZIncrBy(ctx, key, 1, u.tag)
+		pipe.Expire(ctx, key, u.window.Span+u.window.Bucket)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		fmt.Println("Error recording tag use:", err)
+	}
+}
+
+// addedTags returns the tags in after that are not in before
+func addedTags(before, after []string) []string {
+	existing := make(map[string]bool, len(before))
+	for _, tag := range before {
+		existing[tag] = true
+	}
+	added := make([]string, 0)
+	for _, tag := range after {
+		if !existing[tag] {
+			added = append(added, tag)
+		}
+	}
+	return added
+}
+
+// recordLike moves the post's like velocity by delta in every window
+func recordLike(ctx context.Context, post *models.Post, delta float64) {
+	if !countsTowardTrending(post) {
+		return
+	}
+	now := time.Now()
+
+	pipe := deps.Redis.Pipeline()
+	for _, window := range trending.Windows {
+		key := bucketKey("posts", window, window.BucketStart(now))
+		pipe.ZIncrBy(ctx, key, delta, post.ID.Hex())
+		pipe.Expire(ctx, key, window.Span+window.Bucket)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		fmt.Println("Error recording like:", err)
+	}
+}
+
+// forgetTrendingPosts removes the posts from every bucket and cached ranking of every window
+func forgetTrendingPosts(ctx context.Context, postIDs []primitive.ObjectID) error {
+	members := make([]interface{}, len(postIDs))
+	for i, id := range postIDs {
+		members[i] = id.Hex()
+	}
+
+	pipe := deps.Redis.Pipeline()
+	for _, window := range trending.Windows {
+		for _, bucket := range window.Buckets(time.Now()) {
+			pipe.ZRem(ctx, bucketKey("posts", window, bucket.Start), members...)
+		}
+		pipe.ZRem(ctx, fmt.Sprintf("trending:posts:%s:ranked", window.Name), members...)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// countsTowardTrending reports whether the post is public, trending is shown to everyone
+func countsTowardTrending(post *models.Post) bool {
+	public := post.Visibility == "" || post.Visibility == models.VisibilityPublic
+	return public && len(post.PrivateTo) == 0 && post.DeletedAt == nil
+}
+
+// rankedMembers returns the top members of a window, merging its buckets with their decay
+// weights. The merged ranking is kept for trendingRankTTL so reads don't redo the merge.
+func rankedMembers(ctx context.Context, kind string, window trending.Window, count int64) ([]redis.Z, error) {
+	rankKey := fmt.Sprintf("trending:%s:%s:ranked", kind, window.Name)
+
+	exists, err := deps.Redis.Exists(ctx, rankKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		buckets := window.Buckets(time.Now())
+		store := &redis.ZStore{
+			Keys:    make([]string, len(buckets)),
+			Weights: make([]float64, len(buckets)),
+		}
+		for i, bucket := range buckets {
+			store.Keys[i] = bucketKey(kind, window, bucket.Start)
+			store.Weights[i] = bucket.Weight
+		}
+
+		pipe := deps.Redis.TxPipeline()
+		pipe.ZUnionStore(ctx, rankKey, store)
+		pipe.Expire(ctx, rankKey, trendingRankTTL)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return deps.Redis.ZRevRangeWithScores(ctx, rankKey, 0, count-1).Result()
+}
+
+// tagAuthorCount estimates how many different authors used the tag within the window
+func tagAuthorCount(ctx context.Context, window trending.Window, tag string) (int64, error) {
+	buckets := window.Buckets(time.Now())
+	keys := make([]string, len(buckets))
+	for i, bucket := range buckets {
+		keys[i] = tagAuthorsKey(window, bucket.Start, tag)
+	}
+	return deps.Redis.PFCount(ctx, keys...).Result()
+}
+
+func bucketKey(kind string, window trending.Window, start time.Time) string {
+	return fmt.Sprintf("trending:%s:%s:%d", kind, window.Name, start.Unix())
+}
+
+func tagAuthorsKey(window trending.Window, start time.Time, tag string) string {
+	return fmt.Sprintf("trending:tag-authors:%s:%d:%s", window.Name, start.Unix(), tag)
+}
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
@@ -22,19 +23,34 @@ func CreateUser(c *gin.Context) {
 		return
 	}
 
-	// Initialize followers and following as empty arrays if not set
-	if user.Followers == nil {
-		user.Followers = []primitive.ObjectID{}
+	display, handle, err := parseUsername(user.Username)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	if user.Following == nil {
-		user.Following = []primitive.ObjectID{}
+	if err = checkHandleFree(c.Request.Context(), handle, primitive.NilObjectID); err != nil {
+		if errors.Is(err, errUsernameTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check username"})
+		}
+		return
 	}
+	user.Username = display
+	user.Handle = handle
 
+	// Counts are maintained by FollowUser and UnfollowUser
+	user.FollowerCount = 0
+	user.FollowingCount = 0
+	user.CelebrityPinned = false
 	user.CreatedAt = time.Now()
 
 	// Insert the new user into the database
 	result, err := usersCollection.InsertOne(c.Request.Context(), user)
-	if err != nil {
+	if mongo.IsDuplicateKeyError(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": errUsernameTaken.Error()})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
@@ -54,7 +70,7 @@ func GetUser(c *gin.Context) {
 	}
 
 	var user models.User
-	err = usersCollection.FindOne(c.Request.Context(), bson.M{"_id": id}).Decode(&user)
+	err = usersCollection.FindOne(c.Request.Context(), notDeleted(bson.M{"_id": id})).Decode(&user)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
@@ -62,7 +78,11 @@ func GetUser(c *gin.Context) {
 	c.JSON(http.StatusOK, user)
 }
 
-// UpdateUser updates a user's information
+// editableProfileFields are the only fields UpdateUser accepts. The username, privacy and
+// celebrity status have their own endpoints, which keep what depends on them in step.
+var editableProfileFields = map[string]bool{"bio": true}
+
+// UpdateUser updates a user's profile
 func UpdateUser(c *gin.Context) {
 	id, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
@@ -75,40 +95,42 @@ func UpdateUser(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if len(updateData) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update"})
+		return
+	}
+	for field := range updateData {
+		if !editableProfileFields[field] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Field " + field + " cannot be updated"})
+			return
+		}
+		if _, ok := updateData[field].(string); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Field " + field + " must be a string"})
+			return
+		}
+	}
 
-	_, err = usersCollection.UpdateOne(
+	result, err := usersCollection.UpdateOne(
 		c.Request.Context(),
-		bson.M{"_id": id},
+		notDeleted(bson.M{"_id": id}),
 		bson.M{"$set": updateData},
 	)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
 		return
 	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "User updated successfully"})
-}
-
-// DeleteUser deletes a user
-func DeleteUser(c *gin.Context) {
-	id, err := primitive.ObjectIDFromHex(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
 
-	_, err = usersCollection.DeleteOne(c.Request.Context(), bson.M{"_id": id})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "User updated successfully"})
 }
 
 // ListUsers retrieves a list of all users
 func ListUsers(c *gin.Context) {
 	var users []models.User
-	cursor, err := usersCollection.Find(c.Request.Context(), bson.M{})
+	cursor, err := usersCollection.Find(c.Request.Context(), notDeleted(bson.M{}))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve users"})
 		return
@@ -121,113 +143,3 @@ func ListUsers(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, users)
 }
-func FollowUser(c *gin.Context) {
-	followerID, err := primitive.ObjectIDFromHex(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid follower ID"})
-		return
-	}
-	followeeID, err := primitive.ObjectIDFromHex(c.Param("followeeID"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid followee ID"})
-		return
-	}
-
-	// Update followee's followers
-	_, err = usersCollection.UpdateOne(
-		c.Request.Context(),
-		bson.M{"_id": followeeID},
-		bson.M{
-			"$addToSet": bson.M{
-				"followers": followerID,
-			},
-		},
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update followee", "details": err.Error()})
-		return
-	}
-
-	// Update follower's following
-	_, err = usersCollection.UpdateOne(
-		c.Request.Context(),
-		bson.M{"_id": followerID},
-		bson.M{
-			"$addToSet": bson.M{
-				"following": followeeID,
-			},
-		},
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update follower", "details": err.Error()})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "Successfully followed user"})
-}
-
-// UnfollowUser handles the unfollow action
-func UnfollowUser(c *gin.Context) {
-	followerID, err := primitive.ObjectIDFromHex(c.Param("followerID"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid follower ID"})
-		return
-	}
-	followeeID, err := primitive.ObjectIDFromHex(c.Param("followeeID"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid followee ID"})
-		return
-	}
-
-	// Attempt to update the follower and followee in a single operation
-	_, err = usersCollection.UpdateOne(
-		c.Request.Context(),
-		bson.M{"_id": followerID},
-		bson.M{"$pull": bson.M{"following": followeeID}},
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update follower"})
-		return
-	}
-
-	_, err = usersCollection.UpdateOne(
-		c.Request.Context(),
-		bson.M{"_id": followeeID},
-		bson.M{"$pull": bson.M{"followers": followerID}},
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update followee"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "Successfully unfollowed user"})
-}
-
-// SetCelebrityStatus sets the celebrity status of a user
-func SetCelebrityStatus(c *gin.Context) {
-	id, err := primitive.ObjectIDFromHex(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
-		return
-	}
-
-	var status struct {
-		IsCelebrity bool `json:"is_celebrity"`
-	}
-	if err := c.ShouldBindJSON(&status); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	_, err = usersCollection.UpdateOne(
-		c.Request.Context(),
-		bson.M{"_id": id},
-		bson.M{"$set": bson.M{"is_celebrity": status.IsCelebrity}},
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update celebrity status"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "Celebrity status updated"})
-}
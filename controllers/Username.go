@@ -0,0 +1,212 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"feed/entities"
+	"feed/initializers"
+	"feed/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var usernameRedirectsCollection *mongo.Collection = initializers.OpenCollection(initializers.Client, "username_redirects")
+
+var errUsernameTaken = errors.New("username is taken")
+
+// parseUsername checks a requested username against the policy, returning the
+// display form the user typed and the handle it is stored under
+func parseUsername(input string) (string, string, error) {
+	display := strings.TrimPrefix(strings.TrimSpace(input), "@")
+	handle := entities.NormalizeUsername(display)
+	if err := entities.ValidateUsername(handle); err != nil {
+		return "", "", err
+	}
+	return display, handle, nil
+}
+
+// checkHandleFree fails with errUsernameTaken while another user's redirect holds the handle
+func checkHandleFree(ctx context.Context, handle string, userID primitive.ObjectID) error {
+	count, err := usernameRedirectsCollection.CountDocuments(ctx, bson.M{
+		"handle":     handle,
+		"user_id":    bson.M{"$ne": userID},
+		"expires_at": bson.M{"$gt": time.Now()},
+	})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errUsernameTaken
+	}
+	return nil
+}
+
+// GetUserByUsername looks a user up by handle, ignoring case and a leading @.
+// An old handle still in its grace period redirects to the current one.
+func GetUserByUsername(c *gin.Context) {
+	handle := entities.NormalizeUsername(c.Param("username"))
+
+	var user models.User
+	err := usersCollection.FindOne(c.Request.Context(), notDeleted(bson.M{"handle": handle})).Decode(&user)
+	if err == nil {
+		c.JSON(http.StatusOK, user)
+		return
+	} else if err != mongo.ErrNoDocuments {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		return
+	}
+
+	current, err := redirectedUser(c.Request.Context(), handle)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		return
+	}
+	c.Redirect(http.StatusMovedPermanently, "/users/by-username/"+url.PathEscape(current.Handle))
+}
+
+// redirectedUser returns the user an old handle points at while its redirect lasts
+func redirectedUser(ctx context.Context, handle string) (*models.User, error) {
+	var redirect models.UsernameRedirect
+	err := usernameRedirectsCollection.FindOne(ctx, bson.M{
+		"handle":     handle,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&redirect)
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	err = usersCollection.FindOne(ctx, notDeleted(bson.M{"_id": redirect.UserID})).Decode(&user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ChangeUsername renames a user. The old handle redirects to the user for deps.UsernameRedirectGrace
+// and no one else can claim it until then, though the user can take it back.
+func ChangeUsername(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var body struct {
+		Username string `json:"username"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	display, handle, err := parseUsername(body.Username)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = withTransaction(c.Request.Context(), func(sc mongo.SessionContext) error {
+		if err := checkHandleFree(sc, handle, id); err != nil {
+			return err
+		}
+
+		var before models.User
+		err := usersCollection.FindOneAndUpdate(
+			sc,
+			notDeleted(bson.M{"_id": id}),
+			bson.M{"$set": bson.M{"username": display, "handle": handle}},
+		).Decode(&before)
+		if mongo.IsDuplicateKeyError(err) {
+			return errUsernameTaken
+		} else if err != nil {
+			return err
+		}
+
+		// Taking back an old handle ends its redirect
+		if _, err := usernameRedirectsCollection.DeleteMany(sc, bson.M{"handle": handle}); err != nil {
+			return err
+		}
+		if before.Handle == "" || before.Handle == handle {
+			return nil
+		}
+		now := time.Now()
+		_, err = usernameRedirectsCollection.UpdateOne(
+			sc,
+			bson.M{"handle": before.Handle},
+			bson.M{
+				"$set":         bson.M{"user_id": id, "expires_at": now.Add(deps.UsernameRedirectGrace)},
+				"$setOnInsert": bson.M{"created_at": now},
+			},
+			options.Update().SetUpsert(true),
+		)
+		return err
+	})
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case errors.Is(err, errUsernameTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change username"})
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "Username changed", "username": display})
+	}
+}
+
+// migrateUsernames gives every existing user a unique handle. Usernames that break the policy or
+// collide with an older account's are replaced with one derived from the user's ID.
+func migrateUsernames(ctx context.Context) error {
+	cursor, err := usersCollection.Find(
+		ctx,
+		bson.M{},
+		options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+			SetProjection(bson.M{"username": 1, "handle": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	taken := make(map[string]bool)
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+
+		display, handle, err := parseUsername(user.Username)
+		if err != nil || taken[handle] {
+			display = fmt.Sprintf("user_%s", user.ID.Hex()[16:])
+			handle = display
+			fmt.Printf("Renaming user %s from %q to %q\n", user.ID.Hex(), user.Username, display)
+		}
+		taken[handle] = true
+		if display == user.Username && handle == user.Handle {
+			continue
+		}
+
+		_, err = usersCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": user.ID},
+			bson.M{"$set": bson.M{"username": display, "handle": handle}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
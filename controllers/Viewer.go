@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+
+	"feed/models"
+	"feed/visibility"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// viewerID returns the user making the request, as identified by the X-User-ID header
+func viewerID(c *gin.Context) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(c.GetHeader("X-User-ID"))
+	if err != nil {
+		return primitive.NilObjectID, false
+	}
+	return id, true
+}
+
+// checkSelf makes sure the request is made by the given user, writing the error response when not
+func checkSelf(c *gin.Context, userID primitive.ObjectID) bool {
+	id, ok := viewerID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "X-User-ID header is required"})
+		return false
+	}
+	if id != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Users can only manage their own account"})
+		return false
+	}
+	return true
+}
+
+// loadViewer builds the visibility context for a user from their blocks and follows
+func loadViewer(ctx context.Context, userID primitive.ObjectID) (*visibility.Viewer, error) {
+	viewer := visibility.Anonymous()
+	viewer.ID = userID
+
+	var blocks []models.Block
+	cursor, err := blocksCollection.Find(ctx, bson.M{"$or": []bson.M{
+		{"blocker_id": userID},
+		{"blocked_id": userID},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &blocks); err != nil {
+		return nil, err
+	}
+	for _, block := range blocks {
+		if block.BlockerID == userID {
+			viewer.Blocked[block.BlockedID] = true
+		} else {
+			viewer.Blocked[block.BlockerID] = true
+		}
+	}
+
+	following, err := followingIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range following {
+		viewer.Following[id] = true
+	}
+	return viewer, nil
+}
+
+// requestViewer builds the visibility context for the user identified by the request, if any
+func requestViewer(c *gin.Context) (*visibility.Viewer, error) {
+	id, ok := viewerID(c)
+	if !ok {
+		return visibility.Anonymous(), nil
+	}
+	return loadViewer(c.Request.Context(), id)
+}
+
+// visibleTo restricts a post filter to the posts the viewer may see
+func visibleTo(viewer *visibility.Viewer, filter bson.M) bson.M {
+	return bson.M{"$and": []bson.M{filter, viewer.PostFilter()}}
+}
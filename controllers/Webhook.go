@@ -0,0 +1,550 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"feed/initializers"
+	"feed/models"
+	"feed/webhooks"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	webhookSubscriptionsCollection *mongo.Collection = initializers.OpenCollection(initializers.Client, "webhook_subscriptions")
+	webhookDeliveriesCollection    *mongo.Collection = initializers.OpenCollection(initializers.Client, "webhook_deliveries")
+	webhookDeadLettersCollection   *mongo.Collection = initializers.OpenCollection(initializers.Client, "webhook_dead_letters")
+)
+
+var errWebhookGone = errors.New("the webhook of this dead letter was deleted")
+
+// Webhook event types
+const (
+	WebhookPostCreated  = "post.created"
+	WebhookPostLiked    = "post.liked"
+	WebhookUserFollowed = "user.followed"
+)
+
+var webhookEvents = map[string]bool{
+	WebhookPostCreated:  true,
+	WebhookPostLiked:    true,
+	WebhookUserFollowed: true,
+}
+
+// Webhook delivery statuses
+const (
+	WebhookPending   = webhooks.Pending
+	WebhookDelivered = webhooks.Delivered
+	WebhookDead      = webhooks.Dead
+)
+
+// Retry waits double from webhookRetryBase after each failed attempt, up to webhookRetryMax
+const (
+	webhookRetryBase = 30 * time.Second
+	webhookRetryMax  = time.Hour
+)
+
+// webhookDeliveryLease is how long a dispatcher owns a delivery before another may try it
+const webhookDeliveryLease = time.Minute
+
+// webhookLogRetention is how long deliveries stay in the log
+const webhookLogRetention = 7 * 24 * time.Hour
+
+// CreateWebhook subscribes a URL to event types. The signing secret is only ever returned here.
+func CreateWebhook(c *gin.Context) {
+	var body struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	target, err := url.Parse(body.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url must be an absolute http or https URL"})
+		return
+	}
+	if len(body.Events) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "events is empty"})
+		return
+	}
+	for _, event := range body.Events {
+		if !webhookEvents[event] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown event %q", event)})
+			return
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+	subscription := models.WebhookSubscription{
+		URL:       body.URL,
+		Events:    body.Events,
+		Secret:    hex.EncodeToString(secret),
+		CreatedAt: time.Now(),
+	}
+	result, err := webhookSubscriptionsCollection.InsertOne(c.Request.Context(), subscription)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+	subscription.ID = result.InsertedID.(primitive.ObjectID)
+
+	c.JSON(http.StatusCreated, gin.H{"webhook": subscription, "secret": subscription.Secret})
+}
+
+// ListWebhooks lists every webhook subscription
+func ListWebhooks(c *gin.Context) {
+	subscriptions := []models.WebhookSubscription{}
+	cursor, err := webhookSubscriptionsCollection.Find(c.Request.Context(), bson.M{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve webhooks"})
+		return
+	}
+	if err = cursor.All(c.Request.Context(), &subscriptions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode webhooks"})
+		return
+	}
+	c.JSON(http.StatusOK, subscriptions)
+}
+
+// DeleteWebhook removes a subscription along with its pending deliveries
+func DeleteWebhook(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return
+	}
+
+	result, err := webhookSubscriptionsCollection.DeleteOne(c.Request.Context(), bson.M{"_id": id})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	_, err = webhookDeliveriesCollection.DeleteMany(c.Request.Context(), bson.M{"subscription_id": id, "status": WebhookPending})
+	if err != nil {
+		fmt.Println("Error dropping pending webhook deliveries:", err)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted"})
+}
+
+// ListWebhookDeliveries pages through a subscription's delivery log, most recent first, ?status= to filter
+func ListWebhookDeliveries(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return
+	}
+	limit, err := parseLimit(c, 20, 100)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit number"})
+		return
+	}
+	filter, err := cursorFilter(c.Query("cursor"), "created_at")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+	filter["subscription_id"] = id
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+
+	deliveries := []models.WebhookDelivery{}
+	cursor, err := webhookDeliveriesCollection.Find(
+		c.Request.Context(),
+		filter,
+		options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+			SetLimit(int64(limit+1)),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve deliveries"})
+		return
+	}
+	if err = cursor.All(c.Request.Context(), &deliveries); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode deliveries"})
+		return
+	}
+
+	nextCursor := ""
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+		last := deliveries[len(deliveries)-1]
+		nextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"deliveries":  deliveries,
+		"next_cursor": nextCursor,
+	})
+}
+
+// ListWebhookDeadLetters lists the deliveries that ran out of attempts, oldest first
+func ListWebhookDeadLetters(c *gin.Context) {
+	limit, err := parseLimit(c, 50, 200)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit number"})
+		return
+	}
+
+	letters := []models.WebhookDeadLetter{}
+	cursor, err := webhookDeadLettersCollection.Find(
+		c.Request.Context(),
+		bson.M{},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetLimit(int64(limit)),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve dead letters"})
+		return
+	}
+	if err = cursor.All(c.Request.Context(), &letters); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode dead letters"})
+		return
+	}
+	c.JSON(http.StatusOK, letters)
+}
+
+// RetryWebhookDeadLetter requeues the delivery of a dead letter with a full set of attempts
+func RetryWebhookDeadLetter(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dead letter ID"})
+		return
+	}
+
+	var delivery models.WebhookDelivery
+	err = withTransaction(c.Request.Context(), func(sc mongo.SessionContext) error {
+		var letter models.WebhookDeadLetter
+		if err := webhookDeadLettersCollection.FindOneAndDelete(sc, bson.M{"_id": id}).Decode(&letter); err != nil {
+			return err
+		}
+		count, err := webhookSubscriptionsCollection.CountDocuments(sc, bson.M{"_id": letter.SubscriptionID})
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return errWebhookGone
+		}
+
+		filter, update := webhooks.Retry(letter, time.Now())
+		return webhookDeliveriesCollection.FindOneAndUpdate(
+			sc,
+			filter,
+			update,
+			options.FindOneAndUpdate().
+				SetUpsert(true).
+				SetReturnDocument(options.After).
+				SetProjection(bson.M{"_id": 1}),
+		).Decode(&delivery)
+	})
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dead letter not found"})
+	case errors.Is(err, errWebhookGone):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry dead letter"})
+	default:
+		c.JSON(http.StatusAccepted, gin.H{"delivery_id": delivery.ID})
+	}
+}
+
+// webhookConsumerGroup is the event stream consumer group that turns events into deliveries
+const webhookConsumerGroup = "webhooks"
+
+// webhookFanoutBlock is how long the fanout waits for new events before going round again
+const webhookFanoutBlock = 5 * time.Second
+
+// Every webhookClaimInterval the fanout takes over the events another consumer has left
+// unacknowledged for webhookClaimIdle, such as those of a replica that went away
+const (
+	webhookClaimInterval = time.Minute
+	webhookClaimIdle     = 5 * time.Minute
+)
+
+// StartWebhookFanout reads the event stream and queues a delivery of each event to every
+// subscription that wants it, until ctx is cancelled. Before reading new events it goes over
+// the ones it left pending, which are retried that way until their deliveries are queued.
+func StartWebhookFanout(ctx context.Context) {
+	err := deps.Redis.XGroupCreateMkStream(ctx, eventStream, webhookConsumerGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		fmt.Println("Error creating webhook consumer group:", err)
+		return
+	}
+	consumer, err := os.Hostname()
+	if err != nil {
+		consumer = "feed"
+	}
+
+	var claimedAt time.Time
+	for ctx.Err() == nil {
+		heartbeat("webhook_fanout", webhookFanoutBlock)
+		if time.Since(claimedAt) >= webhookClaimInterval {
+			claimedAt = time.Now()
+			if err := claimWebhookEvents(ctx, consumer); err != nil {
+				fmt.Println("Error claiming idle events:", err)
+			}
+		}
+
+		err := fanoutPendingEvents(ctx, consumer)
+		if err == nil {
+			// ">" reads new events, blocking for a while when there are none
+			_, err = fanoutEvents(ctx, consumer, ">", webhookFanoutBlock)
+		}
+		if err != nil {
+			fmt.Println("Error reading event stream:", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}
+}
+
+// claimWebhookEvents moves the events other consumers have left pending for webhookClaimIdle to
+// this consumer, whose next pass over its pending events handles them
+func claimWebhookEvents(ctx context.Context, consumer string) error {
+	pending, err := deps.Redis.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: eventStream,
+		Group:  webhookConsumerGroup,
+		Idle:   webhookClaimIdle,
+		Start:  "-",
+		End:    "+",
+		Count:  100,
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(pending))
+	for _, message := range pending {
+		if message.Consumer != consumer {
+			ids = append(ids, message.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	// MinIdle is checked again by the claim, so an event another consumer just took is left alone
+	return deps.Redis.XClaimJustID(ctx, &redis.XClaimArgs{
+		Stream:   eventStream,
+		Group:    webhookConsumerGroup,
+		Consumer: consumer,
+		MinIdle:  webhookClaimIdle,
+		Messages: ids,
+	}).Err()
+}
+
+// fanoutPendingEvents goes once over the events read by this consumer but not yet acknowledged
+func fanoutPendingEvents(ctx context.Context, consumer string) error {
+	// "0" starts at this consumer's oldest pending event, later reads continue after the last one
+	start := "0"
+	for ctx.Err() == nil {
+		last, err := fanoutEvents(ctx, consumer, start, -1)
+		if err != nil || last == "" {
+			return err
+		}
+		start = last
+	}
+	return nil
+}
+
+// fanoutEvents reads a batch of events from start and queues their deliveries, returning the ID
+// of the last one read. A negative block doesn't wait for events.
+func fanoutEvents(ctx context.Context, consumer, start string, block time.Duration) (string, error) {
+	streams, err := deps.Redis.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    webhookConsumerGroup,
+		Consumer: consumer,
+		Streams:  []string{eventStream, start},
+		Count:    100,
+		Block:    block,
+	}).Result()
+	if err == redis.Nil {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	messages := streams[0].Messages
+	for _, message := range messages {
+		eventID, _ := message.Values["id"].(string)
+		event, _ := message.Values["event"].(string)
+		payload, _ := message.Values["payload"].(string)
+		if err := queueWebhookDeliveries(ctx, eventID, event, payload); err != nil {
+			// Left pending, it is read again on the next pass
+			fmt.Println("Error queueing webhook deliveries for event", eventID+":", err)
+			continue
+		}
+		if err := deps.Redis.XAck(ctx, eventStream, webhookConsumerGroup, message.ID).Err(); err != nil {
+			fmt.Println("Error acknowledging event", eventID+":", err)
+		}
+	}
+	if len(messages) == 0 {
+		return "", nil
+	}
+	return messages[len(messages)-1].ID, nil
+}
+
+// queueWebhookDeliveries queues the event for every subscription that wants it. Deliveries are
+// keyed by event and subscription, so an event seen twice is only delivered once.
+func queueWebhookDeliveries(ctx context.Context, eventID, event, payload string) error {
+	var subscriptions []models.WebhookSubscription
+	cursor, err := webhookSubscriptionsCollection.Find(ctx, bson.M{"events": event}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	if err = cursor.All(ctx, &subscriptions); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, subscription := range subscriptions {
+		_, err := webhookDeliveriesCollection.UpdateOne(
+			ctx,
+			bson.M{"event_id": eventID, "subscription_id": subscription.ID},
+			bson.M{"$setOnInsert": bson.M{
+				"event":           event,
+				"payload":         payload,
+				"status":          WebhookPending,
+				"attempts":        []models.WebhookAttempt{},
+				"next_attempt_at": now,
+				"created_at":      now,
+				"updated_at":      now,
+			}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartWebhookDispatcher sends due webhook deliveries until ctx is cancelled
+func StartWebhookDispatcher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		heartbeat("webhook_dispatcher", interval)
+		for {
+			delivery, err := claimWebhookDelivery(ctx)
+			if err != nil {
+				if err != mongo.ErrNoDocuments {
+					fmt.Println("Error claiming webhook delivery:", err)
+				}
+				break
+			}
+			if err = sendWebhookDelivery(ctx, delivery); err != nil {
+				fmt.Println("Error recording webhook delivery", delivery.ID.Hex()+":", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// claimWebhookDelivery takes the lease on the delivery that has been due the longest
+func claimWebhookDelivery(ctx context.Context) (*models.WebhookDelivery, error) {
+	now := time.Now()
+	var delivery models.WebhookDelivery
+	err := webhookDeliveriesCollection.FindOneAndUpdate(
+		ctx,
+		bson.M{"status": WebhookPending, "next_attempt_at": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"next_attempt_at": now.Add(webhookDeliveryLease)}},
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+			SetReturnDocument(options.After),
+	).Decode(&delivery)
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// sendWebhookDelivery makes one attempt and records it. A failed attempt is retried after a
+// backoff, the last one moves the delivery to the dead letters.
+func sendWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	var subscription models.WebhookSubscription
+	err := webhookSubscriptionsCollection.FindOne(ctx, bson.M{"_id": delivery.SubscriptionID}).Decode(&subscription)
+	if err == mongo.ErrNoDocuments {
+		// The webhook was deleted while this was queued
+		_, err = webhookDeliveriesCollection.DeleteOne(ctx, bson.M{"_id": delivery.ID})
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	result, sendErr := webhookSender.Send(ctx, webhooks.Request{
+		URL:        subscription.URL,
+		Secret:     subscription.Secret,
+		Event:      delivery.Event,
+		DeliveryID: delivery.ID.Hex(),
+		Payload:    []byte(delivery.Payload),
+	})
+	attempt := models.WebhookAttempt{
+		At:         now,
+		StatusCode: result.StatusCode,
+		Response:   result.Body,
+		DurationMS: result.Duration.Milliseconds(),
+	}
+	if sendErr != nil {
+		attempt.Error = sendErr.Error()
+	}
+	set := bson.M{"updated_at": now}
+	update := bson.M{"$push": bson.M{"attempts": attempt}, "$set": set}
+
+	attempts := len(delivery.Attempts) + 1
+	switch {
+	case sendErr == nil:
+		set["status"] = WebhookDelivered
+	case attempts < deps.WebhookMaxAttempts:
+		set["next_attempt_at"] = now.Add(webhooks.Backoff(attempts, webhookRetryBase, webhookRetryMax))
+	default:
+		set["status"] = WebhookDead
+		return withTransaction(ctx, func(sc mongo.SessionContext) error {
+			if _, err := webhookDeliveriesCollection.UpdateOne(sc, bson.M{"_id": delivery.ID}, update); err != nil {
+				return err
+			}
+			_, err := webhookDeadLettersCollection.InsertOne(sc, models.WebhookDeadLetter{
+				DeliveryID:     delivery.ID,
+				SubscriptionID: delivery.SubscriptionID,
+				EventID:        delivery.EventID,
+				Event:          delivery.Event,
+				Payload:        delivery.Payload,
+				LastError:      attempt.Error,
+				CreatedAt:      now,
+			})
+			return err
+		})
+	}
+	_, err = webhookDeliveriesCollection.UpdateOne(ctx, bson.M{"_id": delivery.ID}, update)
+	return err
+}
@@ -0,0 +1,106 @@
+// Package entities finds @mentions and #hashtags in post content.
+package entities
+
+import (
+	"strings"
+	"unicode"
+
+	"feed/models"
+)
+
+// maxHashtagLength is the longest hashtag recognized, longer runs are left as plain text
+// like mentions longer than MaxUsernameLength
+const maxHashtagLength = 100
+
+// Parse returns the mentions and hashtags in content, in order. Offsets count characters.
+// A sigil only starts an entity at the start of the text or after a non-word character,
+// so email addresses and URL fragments are not picked up.
+func Parse(content string) []models.Entity {
+	runes := []rune(content)
+	found := make([]models.Entity, 0)
+
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '@' && runes[i] != '#' {
+			continue
+		}
+		if i > 0 && (isWordRune(runes[i-1]) || runes[i-1] == '@' || runes[i-1] == '#') {
+			continue
+		}
+
+		entityType, valid, maxLength := models.EntityMention, isUsernameRune, MaxUsernameLength
+		if runes[i] == '#' {
+			entityType, valid, maxLength = models.EntityHashtag, isWordRune, maxHashtagLength
+		}
+
+		end := i + 1
+		for end < len(runes) && valid(runes[end]) {
+			end++
+		}
+		value := string(runes[i+1 : end])
+		if value == "" || end-i-1 > maxLength {
+			i = end - 1
+			continue
+		}
+		if entityType == models.EntityHashtag {
+			if !strings.ContainsFunc(value, unicode.IsLetter) {
+				i = end - 1
+				continue
+			}
+			value = NormalizeTag(value)
+		}
+
+		found = append(found, models.Entity{Type: entityType, Start: i, End: end, Value: value})
+		i = end - 1
+	}
+	return found
+}
+
+// Hashtags returns the distinct normalized tags among the entities
+func Hashtags(found []models.Entity) []string {
+	return distinctValues(found, models.EntityHashtag)
+}
+
+// Usernames returns the distinct usernames mentioned among the entities
+func Usernames(found []models.Entity) []string {
+	return distinctValues(found, models.EntityMention)
+}
+
+// MergeTags adds the hashtags to tags, normalizing every tag and dropping duplicates and blanks
+func MergeTags(tags, hashtags []string) []string {
+	merged := make([]string, 0, len(tags)+len(hashtags))
+	present := make(map[string]bool, len(tags)+len(hashtags))
+	for _, tag := range append(append([]string{}, tags...), hashtags...) {
+		tag = NormalizeTag(tag)
+		if tag != "" && !present[tag] {
+			present[tag] = true
+			merged = append(merged, tag)
+		}
+	}
+	return merged
+}
+
+// NormalizeTag folds a tag to the form it is compared and stored in
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+}
+
+func distinctValues(found []models.Entity, entityType string) []string {
+	values := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, entity := range found {
+		key := strings.ToLower(entity.Value)
+		if entity.Type == entityType && !seen[key] {
+			seen[key] = true
+			values = append(values, entity.Value)
+		}
+	}
+	return values
+}
+
+func isUsernameRune(r rune) bool {
+	return r == '_' || (r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)))
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
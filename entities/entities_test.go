@@ -0,0 +1,67 @@
+package entities
+
+import (
+	"reflect"
+	"testing"
+
+	"feed/models"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []models.Entity
+	}{
+		{"no entities here", []models.Entity{}},
+		{"hi @alice", []models.Entity{{Type: models.EntityMention, Start: 3, End: 9, Value: "alice"}}},
+		{"#Go and #go2", []models.Entity{
+			{Type: models.EntityHashtag, Start: 0, End: 3, Value: "go"},
+			{Type: models.EntityHashtag, Start: 8, End: 12, Value: "go2"},
+		}},
+		{"café #Ünïcode", []models.Entity{{Type: models.EntityHashtag, Start: 5, End: 13, Value: "ünïcode"}}},
+		{"(@bob_1), ok", []models.Entity{{Type: models.EntityMention, Start: 1, End: 7, Value: "bob_1"}}},
+		{"mail me at a@b.com", []models.Entity{}},
+		{"issue#12 and #123 and @ alone", []models.Entity{}},
+		{"@@double ##double", []models.Entity{}},
+	}
+
+	for _, tt := range tests {
+		if got := Parse(tt.content); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestMergeTags(t *testing.T) {
+	got := MergeTags([]string{"News", " #go", ""}, []string{"news", "golang"})
+	want := []string{"news", "go", "golang"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MergeTags = %v, want %v", got, want)
+	}
+}
+
+func TestValidateUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		valid    bool
+	}{
+		{"alice", true},
+		{"bob_1", true},
+		{"ab", false},
+		{"a_very_long_username_over_thirty", false},
+		{"Alice", false},
+		{"al ice", false},
+		{"ali.ce", false},
+		{"___", false},
+		{"admin", false},
+	}
+
+	for _, tt := range tests {
+		if err := ValidateUsername(tt.username); (err == nil) != tt.valid {
+			t.Errorf("ValidateUsername(%q) = %v, want valid %v", tt.username, err, tt.valid)
+		}
+	}
+	if got := NormalizeUsername(" @Alice "); got != "alice" {
+		t.Errorf("NormalizeUsername = %q, want alice", got)
+	}
+}
@@ -0,0 +1,48 @@
+package entities
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Username length limits, counted in characters of the normalized handle
+const (
+	MinUsernameLength = 3
+	MaxUsernameLength = 30
+)
+
+// reservedUsernames can't be registered, they would read as the service itself or clash with routes
+var reservedUsernames = map[string]bool{
+	"about": true, "admin": true, "administrator": true, "api": true, "everyone": true,
+	"explore": true, "feed": true, "feeds": true, "help": true, "here": true, "login": true,
+	"logout": true, "me": true, "mod": true, "moderator": true, "null": true, "official": true,
+	"posts": true, "root": true, "search": true, "settings": true, "signup": true, "staff": true,
+	"support": true, "system": true, "tags": true, "trending": true, "undefined": true, "users": true,
+}
+
+var errUsernameCharset = errors.New("username may only contain letters a-z, digits and underscores")
+
+// NormalizeUsername folds a handle to the form it is stored and compared in
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(username), "@"))
+}
+
+// ValidateUsername checks a normalized handle against the username policy
+func ValidateUsername(username string) error {
+	if len(username) < MinUsernameLength || len(username) > MaxUsernameLength {
+		return fmt.Errorf("username must be between %d and %d characters", MinUsernameLength, MaxUsernameLength)
+	}
+	for _, r := range username {
+		if !isUsernameRune(r) || (r >= 'A' && r <= 'Z') {
+			return errUsernameCharset
+		}
+	}
+	if strings.Trim(username, "_") == "" {
+		return errUsernameCharset
+	}
+	if reservedUsernames[username] {
+		return fmt.Errorf("username %q is reserved", username)
+	}
+	return nil
+}
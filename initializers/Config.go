@@ -0,0 +1,36 @@
+package initializers
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// EnvDuration reads a duration such as "15m" from the environment, returning fallback when it is unset
+func EnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid duration for %s: %v", key, err)
+	}
+	return duration
+}
+
+// EnvInt reads an integer from the environment, returning fallback when it is unset
+func EnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Invalid integer for %s: %v", key, err)
+	}
+	return number
+}
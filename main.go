@@ -1,17 +1,120 @@
+// Command feed runs the feed service.
+//
+//	feed          migrations, background jobs and the API in one process
+//	feed serve    the API only
+//	feed worker   background jobs, with a health endpoint on WORKER_ADDR
+//	feed migrate  pending migrations, then exit
 package main
 
 import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"feed/controllers"
 	"feed/initializers"
 	"feed/routes"
+
+	"github.com/gin-gonic/gin"
 )
 
 func init() {
 	initializers.LoadEnvVar()
-	initializers.ConnectDB()
-	initializers.OpenRedis()
+	controllers.Configure(controllers.LoadDeps())
 }
+
 func main() {
+	command := ""
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+	cfg := loadConfig()
 
+	switch command {
+	case "":
+		migrate(context.Background())
+		startJobs(context.Background(), cfg, &sync.WaitGroup{})
+		serve()
+	case "serve":
+		serve()
+	case "worker":
+		// SIGINT and SIGTERM cancel the jobs before the worker exits
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		worker(ctx, cfg)
+	case "migrate":
+		migrate(context.Background())
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q, use serve, worker or migrate\n", command)
+		os.Exit(2)
+	}
+}
+
+// serve runs the API until the process is stopped
+func serve() {
 	r := routes.SetupRoutes()
-	r.Run()
+	if err := r.Run(); err != nil {
+		log.Fatal("Server stopped:", err)
+	}
+}
+
+// worker runs the background jobs and serves their health check until ctx is cancelled, then
+// gives the jobs up to cfg.ShutdownTimeout to finish what they are doing
+func worker(ctx context.Context, cfg config) {
+	var jobs sync.WaitGroup
+	startJobs(ctx, cfg, &jobs)
+
+	r := gin.Default()
+	r.GET("/healthz", controllers.WorkerHealth)
+	go func() {
+		if err := r.Run(cfg.WorkerAddr); err != nil {
+			log.Fatal("Worker health endpoint stopped:", err)
+		}
+	}()
+
+	<-ctx.Done()
+	fmt.Println("Worker stopping")
+
+	stopped := make(chan struct{})
+	go func() {
+		jobs.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		fmt.Println("Worker stopped")
+	case <-time.After(cfg.ShutdownTimeout):
+		fmt.Println("Worker stopped with jobs still running after", cfg.ShutdownTimeout)
+	}
+}
+
+// migrate applies pending migrations and creates indexes
+func migrate(ctx context.Context) {
+	if err := controllers.RunMigrations(ctx); err != nil {
+		log.Fatal("Could not run migrations:", err)
+	}
+}
+
+// startJobs launches every background job, tracked by jobs. They stop when ctx is cancelled.
+func startJobs(ctx context.Context, cfg config, jobs *sync.WaitGroup) {
+	run := func(job func()) {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			job()
+		}()
+	}
+	run(func() { controllers.StartPostPurger(ctx, cfg.PostPurgeInterval) })
+	run(func() { controllers.StartAccountDeletionWorker(ctx, cfg.AccountDeletionInterval) })
+	run(func() { controllers.StartCelebrityPolicy(ctx, cfg.CelebrityPolicyInterval) })
+	run(func() { controllers.StartChangeStreamWorker(ctx, cfg.ChangePollInterval) })
+	run(func() { controllers.StartOutboxRelay(ctx, cfg.OutboxRelayInterval) })
+	run(func() { controllers.StartWebhookFanout(ctx) })
+	run(func() { controllers.StartWebhookDispatcher(ctx, cfg.WebhookDispatchInterval) })
+	run(func() { controllers.StartSuggestionBuilder(ctx, cfg.SuggestionInterval) })
 }
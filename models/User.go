@@ -6,29 +6,269 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// User is an account. Handle is the normalized, unique form of Username that lookups and
+// mentions use, Username keeps the capitalization the user chose.
 type User struct {
-	ID             primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
-	Username       string               `bson:"username" json:"username"`
-	Bio            string               `bson:"bio,omitempty" json:"bio"`
-	Following      []primitive.ObjectID `bson:"following" json:"following"`
-	Followers      []primitive.ObjectID `bson:"followers" json:"followers"`
-	IsCelebrity    bool                 `bson:"is_celebrity" json:"is_celebrity"`
-	CreatedAt      time.Time            `bson:"created_at" json:"created_at"`
-	LastFeedUpdate time.Time            `bson:"last_feed_update" json:"last_feed_update"`
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Username        string             `bson:"username" json:"username"`
+	Handle          string             `bson:"handle" json:"-"`
+	Bio             string             `bson:"bio,omitempty" json:"bio"`
+	FollowerCount   int                `bson:"follower_count" json:"follower_count"`
+	FollowingCount  int                `bson:"following_count" json:"following_count"`
+	IsCelebrity     bool               `bson:"is_celebrity" json:"is_celebrity"`
+	CelebrityPinned bool               `bson:"celebrity_pinned" json:"celebrity_pinned"`
+	IsPrivate       bool               `bson:"is_private" json:"is_private"`
+	FeedMigration   string             `bson:"feed_migration,omitempty" json:"-"`
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+	LastFeedUpdate  time.Time          `bson:"last_feed_update" json:"last_feed_update"`
+	DeletedAt       *time.Time         `bson:"deleted_at,omitempty" json:"-"`
 }
 
-type Post struct {
+// UsernameRedirect points a user's previous handle at them until it expires and the handle is freed
+type UsernameRedirect struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Handle    string             `bson:"handle" json:"handle"`
 	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
-	Content   string             `bson:"content" json:"content"`
-	LikeCount int                `bson:"like_count" json:"like_count"`
-	Tags      []string           `bson:"tags,omitempty" json:"tags"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// UserSummary is the short form of a user used in lists. The relationship flags are
+// only set when the request identifies a viewer.
+type UserSummary struct {
+	ID           primitive.ObjectID `json:"id"`
+	Username     string             `json:"username"`
+	Bio          string             `json:"bio"`
+	IsCelebrity  bool               `json:"is_celebrity"`
+	IsPrivate    bool               `json:"is_private"`
+	FollowedByMe *bool              `json:"followed_by_me,omitempty"`
+	FollowsMe    *bool              `json:"follows_me,omitempty"`
+}
+
+// Block stops two users from seeing or interacting with each other, whichever way it was placed
+type Block struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	BlockerID primitive.ObjectID `bson:"blocker_id" json:"blocker_id"`
+	BlockedID primitive.ObjectID `bson:"blocked_id" json:"blocked_id"`
 	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
 }
 
+// Kinds of things a user can mute
+const (
+	MuteUser    = "user"
+	MuteTag     = "tag"
+	MuteKeyword = "keyword"
+	MuteRegex   = "regex"
+)
+
+// Mute hides matching posts from a user's feed without unfollowing anyone
+type Mute struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Kind      string             `bson:"kind" json:"kind"`
+	Value     string             `bson:"value" json:"value"`
+	ExpiresAt *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// FollowRequest is a pending follow of a private account, waiting for TargetID to approve it
+type FollowRequest struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	RequesterID primitive.ObjectID `bson:"requester_id" json:"requester_id"`
+	TargetID    primitive.ObjectID `bson:"target_id" json:"target_id"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Notification types
+const (
+	NotificationMention       = "mention"
+	NotificationFollow        = "follow"
+	NotificationFollowRequest = "follow_request"
+	NotificationLike          = "like"
+)
+
+// Notification tells UserID that ActorID did something involving them. Similar notifications
+// are grouped while unread: ActorID is the latest actor, ActorIDs the most recent few and
+// ActorCount how many there were in all.
+type Notification struct {
+	ID         primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	UserID     primitive.ObjectID   `bson:"user_id" json:"user_id"`
+	Type       string               `bson:"type" json:"type"`
+	ActorID    primitive.ObjectID   `bson:"actor_id" json:"actor_id"`
+	ActorIDs   []primitive.ObjectID `bson:"actor_ids" json:"actor_ids"`
+	ActorCount int                  `bson:"actor_count" json:"actor_count"`
+	PostID     *primitive.ObjectID  `bson:"post_id,omitempty" json:"post_id,omitempty"`
+	// GroupKey is set while the notification is unread and can take in similar ones,
+	// GroupActorIDs holds every distinct actor of the open group so none is counted twice
+	GroupKey      string               `bson:"group_key,omitempty" json:"-"`
+	GroupActorIDs []primitive.ObjectID `bson:"group_actor_ids,omitempty" json:"-"`
+	CreatedAt     time.Time            `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time            `bson:"updated_at" json:"updated_at"`
+	ReadAt        *time.Time           `bson:"read_at,omitempty" json:"read_at,omitempty"`
+}
+
+// TagFollow brings posts carrying Tag into the user's feed
+type TagFollow struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Tag       string             `bson:"tag" json:"tag"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Follow is an edge of the social graph, FollowerID follows FolloweeID
+type Follow struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	FollowerID primitive.ObjectID `bson:"follower_id" json:"follower_id"`
+	FolloweeID primitive.ObjectID `bson:"followee_id" json:"followee_id"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// AccountDeletionJob tracks the removal of a user's data across collections.
+// CompletedSteps lets an interrupted job resume where it stopped.
+type AccountDeletionJob struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID         primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Status         string             `bson:"status" json:"status"`
+	CompletedSteps []string           `bson:"completed_steps" json:"completed_steps"`
+	PostsRemoved   int                `bson:"posts_removed" json:"posts_removed"`
+	Attempts       int                `bson:"attempts" json:"attempts"`
+	Error          string             `bson:"error,omitempty" json:"error,omitempty"`
+	LeaseUntil     time.Time          `bson:"lease_until" json:"-"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// Like is one user's like of a post, a post's like_count counts them
+type Like struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	PostID    primitive.ObjectID `bson:"post_id" json:"post_id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+type Post struct {
+	ID             primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	UserID         primitive.ObjectID   `bson:"user_id" json:"user_id"`
+	Content        string               `bson:"content" json:"content"`
+	LikeCount      int                  `bson:"like_count" json:"like_count"`
+	Tags           []string             `bson:"tags,omitempty" json:"tags"`
+	CreatedAt      time.Time            `bson:"created_at" json:"created_at"`
+	RepostOf       *primitive.ObjectID  `bson:"repost_of,omitempty" json:"repost_of,omitempty"`
+	RepostOfUserID *primitive.ObjectID  `bson:"repost_of_user_id,omitempty" json:"repost_of_user_id,omitempty"`
+	RepostCount    int                  `bson:"repost_count" json:"repost_count"`
+	Visibility     string               `bson:"visibility,omitempty" json:"visibility"`
+	Mentions       []primitive.ObjectID `bson:"mentions,omitempty" json:"mentions,omitempty"`
+	Entities       []Entity             `bson:"entities,omitempty" json:"entities,omitempty"`
+	EditedAt       *time.Time           `bson:"edited_at,omitempty" json:"edited_at,omitempty"`
+	DeletedAt      *time.Time           `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+
+	// PrivateTo lists the private accounts the post is restricted to, only users following
+	// every one of them may see it. It covers the author and, for reposts, the original's audience.
+	PrivateTo []primitive.ObjectID `bson:"private_to,omitempty" json:"-"`
+
+	// Filled in when a post is served in a feed, never stored
+	Original   *Post    `bson:"-" json:"original,omitempty"`
+	RepostedBy []string `bson:"-" json:"reposted_by,omitempty"`
+}
+
+// Entity types found in post content
+const (
+	EntityMention = "mention"
+	EntityHashtag = "hashtag"
+)
+
+// Entity is a mention or hashtag in a post's content. Start and End are character offsets,
+// End exclusive, and cover the leading @ or #. Value is the username or the normalized tag.
+type Entity struct {
+	Type   string              `bson:"type" json:"type"`
+	Start  int                 `bson:"start" json:"start"`
+	End    int                 `bson:"end" json:"end"`
+	Value  string              `bson:"value" json:"value"`
+	UserID *primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
+}
+
+// Post visibility levels. Posts stored before visibility existed have none and are public.
+const (
+	VisibilityPublic    = "public"
+	VisibilityFollowers = "followers"
+	VisibilityMentioned = "mentioned"
+)
+
+// IsRepost reports whether the post is a plain repost, i.e. one without quote content
+func (p *Post) IsRepost() bool {
+	return p.RepostOf != nil && p.Content == ""
+}
+
+// PostRevision keeps the content and tags a post had before an edit
+type PostRevision struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	PostID   primitive.ObjectID `bson:"post_id" json:"post_id"`
+	EditorID primitive.ObjectID `bson:"editor_id" json:"editor_id"`
+	Content  string             `bson:"content" json:"content"`
+	Tags     []string           `bson:"tags,omitempty" json:"tags"`
+	EditedAt time.Time          `bson:"edited_at" json:"edited_at"`
+}
+
 type Feed struct {
 	ID        primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
 	UserID    primitive.ObjectID   `bson:"user_id" json:"user_id"`
 	Posts     []primitive.ObjectID `bson:"posts" json:"posts"`
 	UpdatedAt time.Time            `bson:"updated_at" json:"updated_at"`
 }
+
+// WebhookSubscription sends the listed event types to URL, signed with Secret
+type WebhookSubscription struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	URL       string             `bson:"url" json:"url"`
+	Events    []string           `bson:"events" json:"events"`
+	Secret    string             `bson:"secret" json:"-"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// WebhookDelivery is one event on its way to one subscription, along with every attempt made.
+// Payload is stored as sent so retries carry the same bytes.
+type WebhookDelivery struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	SubscriptionID primitive.ObjectID `bson:"subscription_id" json:"subscription_id"`
+	EventID        string             `bson:"event_id" json:"event_id"`
+	Event          string             `bson:"event" json:"event"`
+	Payload        string             `bson:"payload" json:"payload"`
+	Status         string             `bson:"status" json:"status"`
+	Attempts       []WebhookAttempt   `bson:"attempts" json:"attempts"`
+	NextAttemptAt  time.Time          `bson:"next_attempt_at" json:"next_attempt_at"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// WebhookAttempt records one try at a delivery
+type WebhookAttempt struct {
+	At         time.Time `bson:"at" json:"at"`
+	StatusCode int       `bson:"status_code,omitempty" json:"status_code,omitempty"`
+	Error      string    `bson:"error,omitempty" json:"error,omitempty"`
+	Response   string    `bson:"response,omitempty" json:"response,omitempty"`
+	DurationMS int64     `bson:"duration_ms" json:"duration_ms"`
+}
+
+// WebhookDeadLetter keeps a delivery that ran out of attempts until it is retried or discarded
+type WebhookDeadLetter struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	DeliveryID     primitive.ObjectID `bson:"delivery_id" json:"delivery_id"`
+	SubscriptionID primitive.ObjectID `bson:"subscription_id" json:"subscription_id"`
+	EventID        string             `bson:"event_id" json:"event_id"`
+	Event          string             `bson:"event" json:"event"`
+	Payload        string             `bson:"payload" json:"payload"`
+	LastError      string             `bson:"last_error" json:"last_error"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// OutboxEntry is an event written in the same transaction as the change it describes.
+// The relay publishes it and sets SentAt, Payload is the message body as published.
+type OutboxEntry struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Event      string             `bson:"event" json:"event"`
+	Payload    string             `bson:"payload" json:"payload"`
+	Attempts   int                `bson:"attempts" json:"attempts"`
+	LeaseUntil time.Time          `bson:"lease_until" json:"-"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	SentAt     *time.Time         `bson:"sent_at,omitempty" json:"sent_at,omitempty"`
+}
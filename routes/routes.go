@@ -10,14 +10,46 @@ func SetupRoutes() *gin.Engine {
 	r := gin.Default()
 
 	// User routes
-	r.POST("/users", controllers.CreateUser)                             // create a new user
-	r.GET("/users/:id", controllers.GetUser)                             // get a user by ID
-	r.PUT("/users/:id", controllers.UpdateUser)                          // update a user
-	r.DELETE("/users/:id", controllers.DeleteUser)                       // delete a user
-	r.GET("/users", controllers.ListUsers)                               // list all users
-	r.POST("/users/:id/follow/:followeeID", controllers.FollowUser)      // follow a user
-	r.POST("/users/:id/unfollow/:followeeID", controllers.UnfollowUser)  // unfollow a user
-	r.PUT("/users/:id/celebrity-status", controllers.SetCelebrityStatus) // set the celebrity status of a user
+	r.POST("/users", controllers.CreateUser)                            // create a new user
+	r.GET("/users/:id", controllers.GetUser)                            // get a user by ID
+	r.PUT("/users/:id", controllers.UpdateUser)                         // update a user
+	r.DELETE("/users/:id", controllers.DeleteUser)                      // delete a user
+	r.GET("/users", controllers.ListUsers)                              // list all users
+	r.POST("/users/:id/follow/:followeeID", controllers.FollowUser)     // follow a user
+	r.POST("/users/:id/unfollow/:followeeID", controllers.UnfollowUser) // unfollow a user
+	r.GET("/users/:id/followers", controllers.ListFollowers)            // list the users following a user
+	r.GET("/users/:id/following", controllers.ListFollowing)            // list the users a user follows
+
+	// Username routes
+	r.GET("/users/by-username/:username", controllers.GetUserByUsername) // get a user by handle
+	r.PUT("/users/:id/username", controllers.ChangeUsername)             // change a user's handle
+
+	// Suggestion routes
+	r.GET("/users/:id/suggestions", controllers.GetSuggestions) // suggest accounts for a user to follow
+
+	// Notification routes
+	r.GET("/users/:id/notifications", controllers.ListNotifications)                       // list a user's notifications
+	r.POST("/users/:id/notifications/read", controllers.MarkNotificationsRead)             // mark notifications read
+	r.GET("/users/:id/notifications/unread-count", controllers.GetUnreadNotificationCount) // count unread notifications
+
+	// Privacy routes
+	r.PUT("/users/:id/privacy", controllers.SetAccountPrivacy)                                  // make an account private or public
+	r.GET("/users/:id/follow-requests", controllers.ListFollowRequests)                         // list pending follow requests
+	r.POST("/users/:id/follow-requests/:requesterID/approve", controllers.ApproveFollowRequest) // approve a follow request
+	r.POST("/users/:id/follow-requests/:requesterID/reject", controllers.RejectFollowRequest)   // reject a follow request
+
+	// Block routes
+	r.POST("/users/:id/block/:blockedID", controllers.BlockUser)     // block a user
+	r.POST("/users/:id/unblock/:blockedID", controllers.UnblockUser) // unblock a user
+	r.GET("/users/:id/blocks", controllers.ListBlocks)               // list the users a user blocked
+
+	// Mute routes
+	r.POST("/users/:id/mutes", controllers.CreateMute)           // mute a user, tag, keyword or pattern
+	r.GET("/users/:id/mutes", controllers.ListMutes)             // list a user's active mutes
+	r.DELETE("/users/:id/mutes/:muteID", controllers.DeleteMute) // remove a mute
+
+	// Account deletion routes
+	r.GET("/account-deletions/:id", controllers.GetAccountDeletion) // get the progress of an account deletion
 
 	// Post routes
 	r.POST("/users/:id/posts", controllers.CreatePost)    // create a new post
@@ -31,7 +63,47 @@ func SetupRoutes() *gin.Engine {
 	r.DELETE("/posts/:id/tags", controllers.RemoveTag)    // remove a tag from a post
 	r.GET("/users/:id/posts", controllers.GetPostsByUser) // get all posts by a user
 
+	// Post history routes
+	r.GET("/posts/:id/revisions", controllers.GetPostRevisions) // get the edit history of a post
+	r.POST("/posts/:id/restore", controllers.RestorePost)       // restore a deleted post
+
+	// Repost routes
+	r.POST("/users/:id/repost/:postID", controllers.RepostPost)   // repost or quote a post
+	r.POST("/users/:id/unrepost/:postID", controllers.UndoRepost) // undo a repost
+
+	// Tag routes
+	r.GET("/tags", controllers.ListTags)                             // list tags by usage
+	r.GET("/tags/:tag/posts", controllers.GetTagPosts)               // get the posts carrying a tag
+	r.POST("/users/:id/tags/:tag/follow", controllers.FollowTag)     // follow a tag
+	r.POST("/users/:id/tags/:tag/unfollow", controllers.UnfollowTag) // unfollow a tag
+	r.GET("/users/:id/tags", controllers.ListFollowedTags)           // list the tags a user follows
+
+	// Search routes
+	r.GET("/search", controllers.SearchPosts) // search posts, ?q= with author, tag, since, until and sort
+
+	// Trending routes
+	r.GET("/trending/tags", controllers.GetTrendingTags)   // trending tags, ?window=1h|24h|7d
+	r.GET("/trending/posts", controllers.GetTrendingPosts) // trending posts, ?window=1h|24h|7d
+
 	// Feed routes
 	r.GET("/feeds/:id", controllers.GetFeed)
+
+	// Health routes
+	r.GET("/healthz", controllers.Health) // check MongoDB and Redis are reachable
+
+	// Admin routes
+	admin := r.Group("/admin", controllers.RequireAdmin)
+	admin.POST("/graph/check", controllers.CheckGraphHandler) // check the follow graph, ?repair=true to fix it
+
+	// Webhook routes
+	admin.POST("/webhooks", controllers.CreateWebhook)                                 // subscribe a URL to events
+	admin.GET("/webhooks", controllers.ListWebhooks)                                   // list webhook subscriptions
+	admin.DELETE("/webhooks/:id", controllers.DeleteWebhook)                           // delete a webhook subscription
+	admin.GET("/webhooks/:id/deliveries", controllers.ListWebhookDeliveries)           // page through a webhook's delivery log
+	admin.GET("/webhooks/dead-letters", controllers.ListWebhookDeadLetters)            // list deliveries that ran out of attempts
+	admin.POST("/webhooks/dead-letters/:id/retry", controllers.RetryWebhookDeadLetter) // queue a dead letter again
+
+	// Admin overrides on user routes
+	r.PUT("/users/:id/celebrity-status", controllers.RequireAdmin, controllers.SetCelebrityStatus) // pin the celebrity status of a user
 	return r
 }
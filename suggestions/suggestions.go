@@ -0,0 +1,92 @@
+// Package suggestions ranks the accounts a user might want to follow. Candidates come from the
+// follow graph (followed by people the user follows), shared tag follows and overall popularity.
+package suggestions
+
+import (
+	"math"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Weights of each signal in a candidate's score
+const (
+	MutualWeight     = 3.0
+	SharedTagWeight  = 1.0
+	PopularityWeight = 0.5
+)
+
+// Candidate is an account that could be suggested along with the signals behind it
+type Candidate struct {
+	UserID primitive.ObjectID `json:"user_id"`
+	// Mutuals is how many of the user's followings follow the candidate
+	Mutuals int `json:"mutuals"`
+	// SharedTags is how many followed tags the user and the candidate have in common
+	SharedTags int `json:"shared_tags"`
+	// Followers is the candidate's follower count
+	Followers int     `json:"followers"`
+	Score     float64 `json:"score"`
+}
+
+// Scored returns the candidate with Score set from its signals. Popularity is logarithmic so a
+// huge account doesn't drown out people the user's network actually follows.
+func (c Candidate) Scored() Candidate {
+	c.Score = MutualWeight*float64(c.Mutuals) +
+		SharedTagWeight*float64(c.SharedTags) +
+		PopularityWeight*math.Log10(1+float64(c.Followers))
+	return c
+}
+
+// Merge combines the signals found for the same account by different sources
+func Merge(candidates ...[]Candidate) []Candidate {
+	merged := make(map[primitive.ObjectID]*Candidate)
+	order := make([]primitive.ObjectID, 0)
+	for _, list := range candidates {
+		for _, candidate := range list {
+			existing, ok := merged[candidate.UserID]
+			if !ok {
+				c := candidate
+				merged[candidate.UserID] = &c
+				order = append(order, candidate.UserID)
+				continue
+			}
+			existing.Mutuals = max(existing.Mutuals, candidate.Mutuals)
+			existing.SharedTags = max(existing.SharedTags, candidate.SharedTags)
+			existing.Followers = max(existing.Followers, candidate.Followers)
+		}
+	}
+
+	result := make([]Candidate, len(order))
+	for i, id := range order {
+		result[i] = *merged[id]
+	}
+	return result
+}
+
+// Exclusions builds the set of accounts never suggested to the user: the user and everyone in
+// lists, which are the accounts the user follows, has blocked or is blocked by, or has asked to follow
+func Exclusions(userID primitive.ObjectID, lists ...[]primitive.ObjectID) map[primitive.ObjectID]bool {
+	exclude := map[primitive.ObjectID]bool{userID: true}
+	for _, list := range lists {
+		for _, id := range list {
+			exclude[id] = true
+		}
+	}
+	return exclude
+}
+
+// Rank scores the candidates, drops the excluded ones and returns at most limit, best first
+func Rank(candidates []Candidate, exclude map[primitive.ObjectID]bool, limit int) []Candidate {
+	ranked := make([]Candidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		if exclude[candidate.UserID] {
+			continue
+		}
+		ranked = append(ranked, candidate.Scored())
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Score > ranked[j].Score })
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked
+}
@@ -0,0 +1,49 @@
+package suggestions
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRank(t *testing.T) {
+	friend, tagged, popular, blocked := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+
+	candidates := Merge(
+		[]Candidate{{UserID: friend, Mutuals: 2}, {UserID: blocked, Mutuals: 5}},
+		[]Candidate{{UserID: tagged, SharedTags: 3}, {UserID: friend, SharedTags: 1}},
+		[]Candidate{{UserID: popular, Followers: 100000}, {UserID: friend, Followers: 10}},
+	)
+	if len(candidates) != 4 {
+		t.Fatalf("Merge returned %d candidates, want 4", len(candidates))
+	}
+
+	ranked := Rank(candidates, map[primitive.ObjectID]bool{blocked: true}, 10)
+	want := []primitive.ObjectID{friend, tagged, popular}
+	if len(ranked) != len(want) {
+		t.Fatalf("Rank returned %d candidates, want %d", len(ranked), len(want))
+	}
+	for i, id := range want {
+		if ranked[i].UserID != id {
+			t.Errorf("ranked[%d] = %+v, want %s", i, ranked[i], id.Hex())
+		}
+	}
+	if ranked[0].Mutuals != 2 || ranked[0].SharedTags != 1 || ranked[0].Followers != 10 {
+		t.Errorf("signals not merged: %+v", ranked[0])
+	}
+
+	if got := Rank(candidates, nil, 1); len(got) != 1 || got[0].UserID != blocked {
+		t.Errorf("Rank with limit 1 = %+v, want only the blocked candidate when nothing is excluded", got)
+	}
+}
+
+func TestExclusions(t *testing.T) {
+	user, followed, blocked, requested, stranger := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+
+	candidates := []Candidate{{UserID: user}, {UserID: followed}, {UserID: blocked}, {UserID: requested}, {UserID: stranger}}
+	exclude := Exclusions(user, []primitive.ObjectID{followed}, []primitive.ObjectID{blocked}, []primitive.ObjectID{requested})
+	ranked := Rank(candidates, exclude, 10)
+	if len(ranked) != 1 || ranked[0].UserID != stranger {
+		t.Errorf("Rank = %+v, want only the stranger", ranked)
+	}
+}
@@ -0,0 +1,95 @@
+// Package trending holds the windowing and ranking rules behind trending tags and posts.
+// Counts live in time buckets, each window sums its buckets with an exponential decay so
+// recent activity outweighs older activity of the same size.
+package trending
+
+import (
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Window is a span of activity trending is computed over
+type Window struct {
+	Name string
+	// Span is how far back the window reaches
+	Span time.Duration
+	// Bucket is the granularity counts are kept at
+	Bucket time.Duration
+	// HalfLife is the age at which a bucket counts for half as much as the current one
+	HalfLife time.Duration
+}
+
+// Windows are the supported windows
+var Windows = []Window{
+	{Name: "1h", Span: time.Hour, Bucket: 5 * time.Minute, HalfLife: 15 * time.Minute},
+	{Name: "24h", Span: 24 * time.Hour, Bucket: time.Hour, HalfLife: 6 * time.Hour},
+	{Name: "7d", Span: 7 * 24 * time.Hour, Bucket: 6 * time.Hour, HalfLife: 36 * time.Hour},
+}
+
+// ParseWindow finds a window by name
+func ParseWindow(name string) (Window, bool) {
+	for _, w := range Windows {
+		if w.Name == name {
+			return w, true
+		}
+	}
+	return Window{}, false
+}
+
+// BucketStart returns the start of the bucket t falls in
+func (w Window) BucketStart(t time.Time) time.Time {
+	return t.Truncate(w.Bucket)
+}
+
+// Bucket is one bucket of a window along with the weight its counts carry
+type Bucket struct {
+	Start  time.Time
+	Weight float64
+}
+
+// Buckets lists the buckets covering the window ending at now, newest first.
+// A bucket's weight halves every HalfLife of age, measured from the bucket's end.
+func (w Window) Buckets(now time.Time) []Bucket {
+	current := w.BucketStart(now)
+	count := int(w.Span / w.Bucket)
+	buckets := make([]Bucket, count)
+	for i := range buckets {
+		start := current.Add(-time.Duration(i) * w.Bucket)
+		age := now.Sub(start.Add(w.Bucket))
+		if age < 0 {
+			age = 0
+		}
+		buckets[i] = Bucket{
+			Start:  start,
+			Weight: math.Pow(0.5, float64(age)/float64(w.HalfLife)),
+		}
+	}
+	return buckets
+}
+
+// Candidate is a ranked item and the author it is attributed to
+type Candidate struct {
+	ID       primitive.ObjectID
+	AuthorID primitive.ObjectID
+	Score    float64
+}
+
+// LimitPerAuthor keeps at most maxPerAuthor candidates from each author, preserving order,
+// and stops after limit, so one prolific account can't fill the list
+func LimitPerAuthor(candidates []Candidate, maxPerAuthor, limit int) []Candidate {
+	kept := make([]Candidate, 0, limit)
+	perAuthor := make(map[primitive.ObjectID]int)
+	for _, candidate := range candidates {
+		if len(kept) == limit {
+			break
+		}
+		if perAuthor[candidate.AuthorID] >= maxPerAuthor {
+			continue
+		}
+		perAuthor[candidate.AuthorID]++
+		kept = append(kept, candidate)
+	}
+	return kept
+}
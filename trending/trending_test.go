@@ -0,0 +1,53 @@
+package trending
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestBuckets(t *testing.T) {
+	window, _ := ParseWindow("24h")
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	buckets := window.Buckets(now)
+
+	if len(buckets) != 24 {
+		t.Fatalf("got %d buckets, want 24", len(buckets))
+	}
+	if !buckets[0].Start.Equal(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)) || buckets[0].Weight != 1 {
+		t.Errorf("current bucket = %+v, want 12:00 with weight 1", buckets[0])
+	}
+	// Bucket 6 ended five and a half hours ago, weights halve every six hours
+	if got := buckets[6].Weight; math.Abs(got-math.Pow(0.5, 5.5/6)) > 1e-9 {
+		t.Errorf("bucket 6 weight = %v", got)
+	}
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i].Weight >= buckets[i-1].Weight {
+			t.Errorf("bucket %d weight %v does not decay from %v", i, buckets[i].Weight, buckets[i-1].Weight)
+		}
+	}
+}
+
+func TestLimitPerAuthor(t *testing.T) {
+	spammer, other := primitive.NewObjectID(), primitive.NewObjectID()
+	candidates := []Candidate{
+		{ID: primitive.NewObjectID(), AuthorID: spammer},
+		{ID: primitive.NewObjectID(), AuthorID: spammer},
+		{ID: primitive.NewObjectID(), AuthorID: spammer},
+		{ID: primitive.NewObjectID(), AuthorID: other},
+		{ID: primitive.NewObjectID(), AuthorID: other},
+	}
+
+	got := LimitPerAuthor(candidates, 2, 3)
+	want := []primitive.ObjectID{candidates[0].ID, candidates[1].ID, candidates[3].ID}
+	if len(got) != len(want) {
+		t.Fatalf("got %d candidates, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].ID != want[i] {
+			t.Errorf("candidate %d = %v, want %v", i, got[i].ID, want[i])
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package visibility
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"feed/entities"
+	"feed/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Mutes hides posts from a feed by author, tag or content
+type Mutes struct {
+	Users    map[primitive.ObjectID]bool
+	Tags     map[string]bool
+	Keywords []string
+	Patterns []*regexp.Regexp
+}
+
+// NewMutes builds the filter from a user's active mutes
+func NewMutes(mutes []models.Mute) (Mutes, error) {
+	m := Mutes{
+		Users: map[primitive.ObjectID]bool{},
+		Tags:  map[string]bool{},
+	}
+	for _, mute := range mutes {
+		if err := m.add(mute); err != nil {
+			return m, err
+		}
+	}
+	return m, nil
+}
+
+// ValidateMute checks a mute before it is stored
+func ValidateMute(mute models.Mute) error {
+	_, err := NewMutes([]models.Mute{mute})
+	return err
+}
+
+func (m *Mutes) add(mute models.Mute) error {
+	value := strings.TrimSpace(mute.Value)
+	if value == "" {
+		return fmt.Errorf("mute value is empty")
+	}
+
+	switch mute.Kind {
+	case models.MuteUser:
+		id, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			return fmt.Errorf("invalid user ID %q", value)
+		}
+		m.Users[id] = true
+	case models.MuteTag:
+		m.Tags[entities.NormalizeTag(value)] = true
+	case models.MuteKeyword:
+		m.Keywords = append(m.Keywords, strings.ToLower(value))
+	case models.MuteRegex:
+		pattern, err := regexp.Compile("(?i)" + value)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %v", err)
+		}
+		m.Patterns = append(m.Patterns, pattern)
+	default:
+		return fmt.Errorf("unknown mute kind %q", mute.Kind)
+	}
+	return nil
+}
+
+// MutesContent reports whether any tag, keyword or pattern mutes are set, which
+// means reposts have to be checked against their original's content
+func (m *Mutes) MutesContent() bool {
+	return len(m.Tags) > 0 || len(m.Keywords) > 0 || len(m.Patterns) > 0
+}
+
+// Hides reports whether the post is muted. original is the reposted post, when known.
+func (m *Mutes) Hides(post, original *models.Post) bool {
+	if m.Users[post.UserID] || (post.RepostOfUserID != nil && m.Users[*post.RepostOfUserID]) {
+		return true
+	}
+	if m.hidesContent(post) {
+		return true
+	}
+	return original != nil && m.hidesContent(original)
+}
+
+func (m *Mutes) hidesContent(post *models.Post) bool {
+	for _, tag := range post.Tags {
+		if m.Tags[entities.NormalizeTag(tag)] {
+			return true
+		}
+	}
+
+	content := strings.ToLower(post.Content)
+	for _, keyword := range m.Keywords {
+		if strings.Contains(content, keyword) {
+			return true
+		}
+	}
+	for _, pattern := range m.Patterns {
+		if pattern.MatchString(post.Content) {
+			return true
+		}
+	}
+	return false
+}
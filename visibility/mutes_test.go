@@ -0,0 +1,79 @@
+package visibility
+
+import (
+	"testing"
+
+	"feed/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNewMutes(t *testing.T) {
+	tests := []struct {
+		name    string
+		mute    models.Mute
+		wantErr bool
+	}{
+		{"user", models.Mute{Kind: models.MuteUser, Value: primitive.NewObjectID().Hex()}, false},
+		{"invalid user", models.Mute{Kind: models.MuteUser, Value: "someone"}, true},
+		{"tag", models.Mute{Kind: models.MuteTag, Value: "#Spoilers"}, false},
+		{"keyword", models.Mute{Kind: models.MuteKeyword, Value: "election"}, false},
+		{"regex", models.Mute{Kind: models.MuteRegex, Value: `crypto\w*`}, false},
+		{"invalid regex", models.Mute{Kind: models.MuteRegex, Value: "("}, true},
+		{"empty", models.Mute{Kind: models.MuteKeyword, Value: "  "}, true},
+		{"unknown kind", models.Mute{Kind: "mood", Value: "grumpy"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateMute(tt.mute)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateMute = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMutesHides(t *testing.T) {
+	muted := primitive.NewObjectID()
+	other := primitive.NewObjectID()
+	mutes, err := NewMutes([]models.Mute{
+		{Kind: models.MuteUser, Value: muted.Hex()},
+		{Kind: models.MuteTag, Value: "#Spoilers"},
+		{Kind: models.MuteKeyword, Value: "Election"},
+		{Kind: models.MuteRegex, Value: `crypto\w*`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		post     models.Post
+		original *models.Post
+		want     bool
+	}{
+		{"unmuted", models.Post{UserID: other, Content: "hello", Tags: []string{"go"}}, nil, false},
+		{"muted author", models.Post{UserID: muted, Content: "hello"}, nil, true},
+		{"repost of muted author", models.Post{UserID: other, RepostOf: &other, RepostOfUserID: &muted}, nil, true},
+		{"muted tag", models.Post{UserID: other, Tags: []string{"spoilers"}}, nil, true},
+		{"muted tag in another case", models.Post{UserID: other, Tags: []string{"Spoilers"}}, nil, true},
+		{"keyword ignores case", models.Post{UserID: other, Content: "ELECTION night"}, nil, true},
+		{"pattern ignores case", models.Post{UserID: other, Content: "buy CryptoCoins"}, nil, true},
+		{"repost of muted content", models.Post{UserID: other, RepostOf: &other}, &models.Post{UserID: other, Content: "election"}, true},
+		{"repost of unmuted content", models.Post{UserID: other, RepostOf: &other}, &models.Post{UserID: other, Content: "hello"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mutes.Hides(&tt.post, tt.original); got != tt.want {
+				t.Errorf("Hides = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if none, _ := NewMutes(nil); none.MutesContent() {
+		t.Error("MutesContent with no mutes = true, want false")
+	}
+	if !mutes.MutesContent() {
+		t.Error("MutesContent with content mutes = false, want true")
+	}
+}
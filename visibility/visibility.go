@@ -0,0 +1,163 @@
+// Package visibility decides which posts and users a viewer may see and interact with.
+// Relationships are loaded by the caller, the checks here never touch the database.
+package visibility
+
+import (
+	"feed/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Viewer is who content is shown to, along with the relationships that decide what they see
+type Viewer struct {
+	// ID is the viewing user, the zero ID for anonymous requests
+	ID primitive.ObjectID
+	// Blocked holds users the viewer blocked or who blocked the viewer
+	Blocked map[primitive.ObjectID]bool
+	// Following holds the users the viewer follows, which opens up their private posts
+	Following map[primitive.ObjectID]bool
+	// Mutes only apply to the viewer's own feed
+	Mutes Mutes
+}
+
+// Anonymous returns a viewer with no identity and no relationships
+func Anonymous() *Viewer {
+	mutes, _ := NewMutes(nil)
+	return &Viewer{
+		Blocked:   map[primitive.ObjectID]bool{},
+		Following: map[primitive.ObjectID]bool{},
+		Mutes:     mutes,
+	}
+}
+
+// IsAnonymous reports whether the viewer is unidentified
+func (v *Viewer) IsAnonymous() bool {
+	return v.ID.IsZero()
+}
+
+// CanSeeUser reports whether the viewer may see the user's profile and content
+func (v *Viewer) CanSeeUser(userID primitive.ObjectID) bool {
+	return !v.Blocked[userID]
+}
+
+// CanSeeConnections reports whether the viewer may list who the user follows and is followed by.
+// A private account only shows them to itself and its approved followers.
+func (v *Viewer) CanSeeConnections(user *models.User) bool {
+	if !v.CanSeeUser(user.ID) {
+		return false
+	}
+	return !user.IsPrivate || v.canSeePrivate(user.ID)
+}
+
+// CanSee reports whether the viewer may see the post. Reposts and quotes are hidden
+// when the viewer may not see the original's author either.
+func (v *Viewer) CanSee(post *models.Post) bool {
+	if !v.CanSeeUser(post.UserID) {
+		return false
+	}
+	if post.RepostOfUserID != nil && !v.CanSeeUser(*post.RepostOfUserID) {
+		return false
+	}
+	for _, id := range post.PrivateTo {
+		if !v.canSeePrivate(id) {
+			return false
+		}
+	}
+	return v.inAudience(post)
+}
+
+// inAudience reports whether the viewer is among those the author shared the post with
+func (v *Viewer) inAudience(post *models.Post) bool {
+	if !v.IsAnonymous() && post.UserID == v.ID {
+		return true
+	}
+	switch post.Visibility {
+	case models.VisibilityFollowers:
+		return v.Following[post.UserID]
+	case models.VisibilityMentioned:
+		if v.IsAnonymous() {
+			return false
+		}
+		for _, id := range post.Mentions {
+			if id == v.ID {
+				return true
+			}
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+// ValidVisibility reports whether level is a visibility a post can be created with
+func ValidVisibility(level string) bool {
+	switch level {
+	case models.VisibilityPublic, models.VisibilityFollowers, models.VisibilityMentioned:
+		return true
+	}
+	return false
+}
+
+// canSeePrivate reports whether the viewer may see what the private account shares
+func (v *Viewer) canSeePrivate(userID primitive.ObjectID) bool {
+	return (!v.IsAnonymous() && userID == v.ID) || v.Following[userID]
+}
+
+// CanInteract reports whether the viewer may like, repost or reply to the post
+func (v *Viewer) CanInteract(post *models.Post) bool {
+	return v.CanSee(post)
+}
+
+// PostFilter narrows a post query to what the viewer may see, so filtering happens
+// before pagination rather than leaving short pages
+func (v *Viewer) PostFilter() bson.M {
+	// Every private account the post is restricted to must be one the viewer can see
+	allowed := v.FollowingIDs()
+	if !v.IsAnonymous() {
+		allowed = append(allowed, v.ID)
+	}
+	filter := bson.M{
+		"private_to": bson.M{"$not": bson.M{"$elemMatch": bson.M{"$nin": allowed}}},
+		"$or":        v.audienceFilter(),
+	}
+
+	if blocked := v.BlockedIDs(); len(blocked) > 0 {
+		filter["user_id"] = bson.M{"$nin": blocked}
+		filter["repost_of_user_id"] = bson.M{"$nin": blocked}
+	}
+	return filter
+}
+
+// audienceFilter is the query form of inAudience
+func (v *Viewer) audienceFilter() []bson.M {
+	audience := []bson.M{
+		{"visibility": bson.M{"$nin": []string{models.VisibilityFollowers, models.VisibilityMentioned}}},
+		{"visibility": models.VisibilityFollowers, "user_id": bson.M{"$in": v.FollowingIDs()}},
+	}
+	if !v.IsAnonymous() {
+		audience = append(audience,
+			bson.M{"user_id": v.ID},
+			bson.M{"visibility": models.VisibilityMentioned, "mentions": v.ID},
+		)
+	}
+	return audience
+}
+
+// FollowingIDs lists the users the viewer follows
+func (v *Viewer) FollowingIDs() []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(v.Following))
+	for id := range v.Following {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// BlockedIDs lists the users in a block relationship with the viewer
+func (v *Viewer) BlockedIDs() []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(v.Blocked))
+	for id := range v.Blocked {
+		ids = append(ids, id)
+	}
+	return ids
+}
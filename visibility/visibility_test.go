@@ -0,0 +1,263 @@
+package visibility
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"feed/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCanSeeVisibility(t *testing.T) {
+	author := primitive.NewObjectID()
+	follower := primitive.NewObjectID()
+	mentioned := primitive.NewObjectID()
+	mentionedFollower := primitive.NewObjectID()
+	stranger := primitive.NewObjectID()
+	blockedFollower := primitive.NewObjectID()
+
+	viewer := func(id primitive.ObjectID, follows bool) *Viewer {
+		v := Anonymous()
+		v.ID = id
+		if follows {
+			v.Following[author] = true
+		}
+		return v
+	}
+	blocked := viewer(blockedFollower, true)
+	blocked.Blocked[author] = true
+
+	viewers := []struct {
+		name   string
+		viewer *Viewer
+	}{
+		{"anonymous", Anonymous()},
+		{"author", viewer(author, false)},
+		{"follower", viewer(follower, true)},
+		{"mentioned", viewer(mentioned, false)},
+		{"mentioned follower", viewer(mentionedFollower, true)},
+		{"stranger", viewer(stranger, false)},
+		{"blocked follower", blocked},
+	}
+
+	// want lists, per visibility, the viewers that may see the post
+	tests := []struct {
+		visibility string
+		want       map[string]bool
+	}{
+		{"", map[string]bool{
+			"anonymous": true, "author": true, "follower": true, "mentioned": true,
+			"mentioned follower": true, "stranger": true,
+		}},
+		{models.VisibilityPublic, map[string]bool{
+			"anonymous": true, "author": true, "follower": true, "mentioned": true,
+			"mentioned follower": true, "stranger": true,
+		}},
+		{models.VisibilityFollowers, map[string]bool{
+			"author": true, "follower": true, "mentioned follower": true,
+		}},
+		{models.VisibilityMentioned, map[string]bool{
+			"author": true, "mentioned": true, "mentioned follower": true,
+		}},
+	}
+
+	for _, tt := range tests {
+		post := &models.Post{
+			UserID:     author,
+			Visibility: tt.visibility,
+			Mentions:   []primitive.ObjectID{mentioned, mentionedFollower},
+		}
+		for _, v := range viewers {
+			checkVisibility(t, fmt.Sprintf("visibility %q, viewer %s", tt.visibility, v.name), v.viewer, post, tt.want[v.name])
+		}
+	}
+}
+
+func TestCanSeePrivateAccount(t *testing.T) {
+	author := primitive.NewObjectID()
+	follower := Anonymous()
+	follower.ID = primitive.NewObjectID()
+	follower.Following[author] = true
+	stranger := Anonymous()
+	stranger.ID = primitive.NewObjectID()
+
+	post := &models.Post{UserID: author, PrivateTo: []primitive.ObjectID{author}}
+
+	tests := []struct {
+		name   string
+		viewer *Viewer
+		want   bool
+	}{
+		{"anonymous", Anonymous(), false},
+		{"follower", follower, true},
+		{"stranger", stranger, false},
+	}
+	for _, tt := range tests {
+		checkVisibility(t, tt.name, tt.viewer, post, tt.want)
+	}
+}
+
+func TestCanSeeConnections(t *testing.T) {
+	user := primitive.NewObjectID()
+	self := Anonymous()
+	self.ID = user
+	follower := Anonymous()
+	follower.ID = primitive.NewObjectID()
+	follower.Following[user] = true
+	stranger := Anonymous()
+	stranger.ID = primitive.NewObjectID()
+	blocked := Anonymous()
+	blocked.ID = primitive.NewObjectID()
+	blocked.Blocked[user] = true
+
+	tests := []struct {
+		name    string
+		viewer  *Viewer
+		private bool
+		want    bool
+	}{
+		{"anonymous, public", Anonymous(), false, true},
+		{"blocked, public", blocked, false, false},
+		{"anonymous, private", Anonymous(), true, false},
+		{"stranger, private", stranger, true, false},
+		{"follower, private", follower, true, true},
+		{"self, private", self, true, true},
+	}
+	for _, tt := range tests {
+		got := tt.viewer.CanSeeConnections(&models.User{ID: user, IsPrivate: tt.private})
+		if got != tt.want {
+			t.Errorf("%s: CanSeeConnections = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCanSeeBlockedRepost(t *testing.T) {
+	author := primitive.NewObjectID()
+	reposter := primitive.NewObjectID()
+	original := primitive.NewObjectID()
+	viewer := Anonymous()
+	viewer.ID = primitive.NewObjectID()
+	viewer.Blocked[author] = true
+
+	tests := []struct {
+		name string
+		post *models.Post
+		want bool
+	}{
+		{"post by blocked user", &models.Post{UserID: author}, false},
+		{"repost of blocked user", &models.Post{UserID: reposter, RepostOf: &original, RepostOfUserID: &author}, false},
+		{"repost of someone else", &models.Post{UserID: reposter, RepostOf: &original, RepostOfUserID: &reposter}, true},
+	}
+	for _, tt := range tests {
+		checkVisibility(t, tt.name, viewer, tt.post, tt.want)
+	}
+}
+
+// checkVisibility checks CanSee and the query PostFilter builds agree on whether the viewer sees the post
+func checkVisibility(t *testing.T, name string, viewer *Viewer, post *models.Post, want bool) {
+	t.Helper()
+	if got := viewer.CanSee(post); got != want {
+		t.Errorf("%s: CanSee = %v, want %v", name, got, want)
+	}
+
+	raw, err := bson.Marshal(post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if got := matches(doc, viewer.PostFilter()); got != want {
+		t.Errorf("%s: PostFilter matches = %v, want %v", name, got, want)
+	}
+}
+
+// matches evaluates the subset of the MongoDB query language PostFilter uses against a document
+func matches(doc bson.M, filter bson.M) bool {
+	for key, condition := range filter {
+		switch key {
+		case "$or":
+			matched := false
+			for _, clause := range condition.([]bson.M) {
+				matched = matched || matches(doc, clause)
+			}
+			if !matched {
+				return false
+			}
+		default:
+			if !matchesField(doc[key], condition) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matchesField evaluates one field condition, an operator document or a value to equal
+func matchesField(value interface{}, condition interface{}) bool {
+	operators, ok := condition.(bson.M)
+	if !ok {
+		return equalsAny(value, condition)
+	}
+	for operator, operand := range operators {
+		switch operator {
+		case "$in":
+			if !inList(value, operand) {
+				return false
+			}
+		case "$nin":
+			if inList(value, operand) {
+				return false
+			}
+		case "$not":
+			if matchesField(value, operand) {
+				return false
+			}
+		case "$elemMatch":
+			found := false
+			for _, element := range elements(value) {
+				found = found || matchesField(element, operand)
+			}
+			if !found {
+				return false
+			}
+		default:
+			panic("unsupported operator " + operator)
+		}
+	}
+	return true
+}
+
+// equalsAny reports whether the value, or for arrays one of its elements, equals want
+func equalsAny(value interface{}, want interface{}) bool {
+	if array, ok := value.(bson.A); ok {
+		for _, element := range array {
+			if reflect.DeepEqual(element, want) {
+				return true
+			}
+		}
+		return false
+	}
+	return reflect.DeepEqual(value, want)
+}
+
+// inList reports whether the value matches any entry of list, a missing value never does
+func inList(value interface{}, list interface{}) bool {
+	entries := reflect.ValueOf(list)
+	for i := 0; i < entries.Len(); i++ {
+		if value != nil && equalsAny(value, entries.Index(i).Interface()) {
+			return true
+		}
+	}
+	return false
+}
+
+// elements returns an array field's elements, nothing for a missing field
+func elements(value interface{}) []interface{} {
+	array, _ := value.(bson.A)
+	return array
+}
@@ -0,0 +1,179 @@
+// Package webhooks signs and sends webhook payloads. Scheduling, retries and storage are left to
+// the caller, so a delivery here is a single HTTP attempt; Retry only builds the update that
+// requeues a dead one.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"feed/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Headers set on every delivery
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// Delivery statuses
+const (
+	Pending   = "pending"
+	Delivered = "delivered"
+	Dead      = "dead"
+)
+
+// maxResponseBody is how much of a receiver's response is kept for the delivery log
+const maxResponseBody = 1024
+
+var errInvalidSignature = errors.New("invalid webhook signature")
+
+// Sign returns the signature header value for a payload sent at the given time.
+// It has the form t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<payload>">.
+func Sign(secret string, at time.Time, payload []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + signature(secret, timestamp, payload)
+}
+
+// Verify checks a signature header against the payload, rejecting signatures older than tolerance.
+// Receivers can use it as is, it is also what the tests check deliveries with.
+func Verify(secret, header string, payload []byte, tolerance time.Duration, now time.Time) error {
+	var timestamp, sig string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			sig = value
+		}
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || sig == "" {
+		return errInvalidSignature
+	}
+	if now.Sub(time.Unix(seconds, 0)) > tolerance {
+		return fmt.Errorf("%w: too old", errInvalidSignature)
+	}
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, timestamp, payload))) {
+		return errInvalidSignature
+	}
+	return nil
+}
+
+func signature(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff is the wait before retry number attempt, counting from 1. It doubles every attempt
+// from base and is capped at max.
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	wait := base
+	for i := 1; i < attempt; i++ {
+		wait *= 2
+		if wait >= max {
+			return max
+		}
+	}
+	return wait
+}
+
+// Retry returns the filter and update that requeue the dead letter's delivery with a full set of
+// attempts, due at now. The delivery is reset in place, it is keyed by event and subscription,
+// and upserted under its original ID when the delivery log has already expired it.
+func Retry(letter models.WebhookDeadLetter, now time.Time) (bson.M, bson.M) {
+	filter := bson.M{"event_id": letter.EventID, "subscription_id": letter.SubscriptionID}
+	update := bson.M{
+		"$set": bson.M{
+			"status":          Pending,
+			"attempts":        bson.A{},
+			"next_attempt_at": now,
+			"updated_at":      now,
+			// A fresh log entry, so the log's expiry doesn't drop it mid retry
+			"created_at": now,
+		},
+		"$setOnInsert": bson.M{
+			"_id":     letter.DeliveryID,
+			"event":   letter.Event,
+			"payload": letter.Payload,
+		},
+	}
+	return filter, update
+}
+
+// Request is one delivery to send
+type Request struct {
+	URL        string
+	Secret     string
+	Event      string
+	DeliveryID string
+	Payload    []byte
+}
+
+// Result is the outcome of one attempt, StatusCode is zero when no response came back
+type Result struct {
+	StatusCode int
+	Body       string
+	Duration   time.Duration
+}
+
+// Sender posts signed payloads
+type Sender struct {
+	Client *http.Client
+	// Now is the clock signatures are made with, time.Now when nil
+	Now func() time.Time
+}
+
+// Send makes one delivery attempt. Any response outside 2xx is an error.
+func (s *Sender) Send(ctx context.Context, req Request) (Result, error) {
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, req.URL, bytes.NewReader(req.Payload))
+	if err != nil {
+		return Result{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(EventHeader, req.Event)
+	httpReq.Header.Set(DeliveryHeader, req.DeliveryID)
+	httpReq.Header.Set(SignatureHeader, Sign(req.Secret, now(), req.Payload))
+
+	start := time.Now()
+	resp, err := client.Do(httpReq)
+	result := Result{Duration: time.Since(start)}
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	result.StatusCode = resp.StatusCode
+	result.Body = string(body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return result, fmt.Errorf("receiver responded %d", resp.StatusCode)
+	}
+	return result, nil
+}
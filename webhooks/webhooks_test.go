@@ -0,0 +1,190 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"feed/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSendSignsPayload(t *testing.T) {
+	const secret = "s3cret"
+	payload := []byte(`{"type":"post.created"}`)
+	at := time.Unix(1700000000, 0)
+
+	var verifyErr error
+	var event, delivery string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		verifyErr = Verify(secret, r.Header.Get(SignatureHeader), body, 5*time.Minute, at.Add(time.Minute))
+		event, delivery = r.Header.Get(EventHeader), r.Header.Get(DeliveryHeader)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	sender := &Sender{Client: receiver.Client(), Now: func() time.Time { return at }}
+	result, err := sender.Send(context.Background(), Request{
+		URL:        receiver.URL,
+		Secret:     secret,
+		Event:      "post.created",
+		DeliveryID: "d1",
+		Payload:    payload,
+	})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if result.StatusCode != http.StatusNoContent {
+		t.Errorf("StatusCode = %d, want %d", result.StatusCode, http.StatusNoContent)
+	}
+	if verifyErr != nil {
+		t.Errorf("receiver could not verify signature: %v", verifyErr)
+	}
+	if event != "post.created" || delivery != "d1" {
+		t.Errorf("headers = %q, %q", event, delivery)
+	}
+}
+
+func TestSendFailsOnErrorStatus(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "try later", http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	sender := &Sender{Client: receiver.Client()}
+	result, err := sender.Send(context.Background(), Request{URL: receiver.URL, Secret: "s", Payload: []byte("{}")})
+	if err == nil {
+		t.Fatal("Send() succeeded on a 503")
+	}
+	if result.StatusCode != http.StatusServiceUnavailable || result.Body != "try later\n" {
+		t.Errorf("result = %+v", result)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	payload := []byte("{}")
+	at := time.Unix(1700000000, 0)
+	header := Sign("right", at, payload)
+
+	tests := []struct {
+		name    string
+		secret  string
+		header  string
+		payload []byte
+		now     time.Time
+	}{
+		{"wrong secret", "wrong", header, payload, at},
+		{"tampered payload", "right", header, []byte(`{"x":1}`), at},
+		{"too old", "right", header, payload, at.Add(time.Hour)},
+		{"malformed", "right", "v1=abc", payload, at},
+	}
+	for _, tt := range tests {
+		if err := Verify(tt.secret, tt.header, tt.payload, 5*time.Minute, tt.now); err == nil {
+			t.Errorf("%s: Verify() accepted the signature", tt.name)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	want := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for i, w := range want {
+		if got := Backoff(i+1, 30*time.Second, 5*time.Minute); got != w {
+			t.Errorf("Backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}
+
+func TestRetryDeadLetter(t *testing.T) {
+	letter := models.WebhookDeadLetter{
+		DeliveryID:     primitive.NewObjectID(),
+		SubscriptionID: primitive.NewObjectID(),
+		EventID:        "event-1",
+		Event:          "post.created",
+		Payload:        `{"type":"post.created"}`,
+	}
+	created := time.Unix(1700000000, 0)
+	now := created.Add(24 * time.Hour)
+
+	dead := bson.M{
+		"_id":             letter.DeliveryID,
+		"event_id":        letter.EventID,
+		"subscription_id": letter.SubscriptionID,
+		"event":           letter.Event,
+		"payload":         letter.Payload,
+		"status":          Dead,
+		"attempts":        bson.A{bson.M{"status_code": 500}, bson.M{"status_code": 502}},
+		"next_attempt_at": created,
+		"created_at":      created,
+		"updated_at":      created,
+	}
+	tests := []struct {
+		name     string
+		existing []bson.M
+	}{
+		{"delivery still logged", []bson.M{dead}},
+		{"delivery expired from the log", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, update := Retry(letter, now)
+			got := upsert(tt.existing, filter, update)
+
+			want := bson.M{
+				"_id":             letter.DeliveryID,
+				"event_id":        letter.EventID,
+				"subscription_id": letter.SubscriptionID,
+				"event":           letter.Event,
+				"payload":         letter.Payload,
+				"status":          Pending,
+				"attempts":        bson.A{},
+				"next_attempt_at": now,
+				"created_at":      now,
+				"updated_at":      now,
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("retried delivery = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// upsert applies an upsert the way MongoDB does for an equality filter and $set/$setOnInsert,
+// returning the resulting document
+func upsert(documents []bson.M, filter, update bson.M) bson.M {
+	for _, document := range documents {
+		matches := true
+		for key, value := range filter {
+			if document[key] != value {
+				matches = false
+			}
+		}
+		if matches {
+			result := bson.M{}
+			for key, value := range document {
+				result[key] = value
+			}
+			for key, value := range update["$set"].(bson.M) {
+				result[key] = value
+			}
+			return result
+		}
+	}
+
+	result := bson.M{}
+	for _, operator := range []string{"$setOnInsert", "$set"} {
+		for key, value := range update[operator].(bson.M) {
+			result[key] = value
+		}
+	}
+	for key, value := range filter {
+		result[key] = value
+	}
+	return result
+}